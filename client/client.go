@@ -0,0 +1,287 @@
+// Package client is a typed Go client for the stock service. It sends requests over an existing NATS
+// connection, decodes the responses, and turns error responses into Go errors, eg:
+//
+//	stock, err := client.New(nc, client.WithRetries(3, 100*time.Millisecond))
+//	level, err := stock.Remove(ctx, "coffee-cup", 2)
+//	if errors.Is(err, client.ErrInsufficientStock) {
+//		...
+//	}
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DefaultTimeout is how long a request waits for a response when its context has no deadline
+	DefaultTimeout = 5 * time.Second
+
+	// RequestTimeoutHeader tells the service how long the client waits for a response, so the service
+	// stops working on a request the client has given up on
+	RequestTimeoutHeader = "Request-Timeout"
+
+	// RetryAfterHeader holds the number of seconds to wait before retrying a rate limited request
+	RetryAfterHeader = "Retry-After"
+
+	// stockGroup prefixes the subject of every stock endpoint
+	stockGroup = "stock"
+)
+
+// StockClient calls the endpoints of the stock service. It is safe for concurrent use.
+type StockClient struct {
+	nc        *nats.Conn
+	opts      options
+	validator *validator
+}
+
+type options struct {
+	timeout      time.Duration
+	attempts     int
+	backoff      time.Duration
+	version      string
+	headers      nats.Header
+	validate     bool
+	onEventError func(msg *nats.Msg, err error)
+}
+
+// Option configures a StockClient
+type Option func(*options)
+
+// WithTimeout sets how long a request waits for a response when its context has no deadline
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = timeout }
+}
+
+// WithRetries sends a request up to attempts times in all, while it fails in a way that is safe to retry,
+// see IsRetryable. The client waits for backoff before the first retry, doubling it each time after,
+// or for as long as a rate limited response asks.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithAPIVersion calls the endpoints of an API version, eg: `v1`, which is the default. An empty version
+// calls the unversioned subjects, eg: `stock.add`, that services older than the versioned API serve.
+func WithAPIVersion(version string) Option {
+	return func(o *options) { o.version = version }
+}
+
+// WithHeader adds a header to every request, eg: the tenant header when calling from a trusted gateway
+func WithHeader(key, value string) Option {
+	return func(o *options) { o.headers.Add(key, value) }
+}
+
+// WithValidation checks each request against its schema before it is sent, so a bad request fails with
+// ErrValidationFailed without a round trip to the service
+func WithValidation() Option {
+	return func(o *options) { o.validate = true }
+}
+
+// WithEventErrorHandler is called with each event or watch update that can't be decoded. By default
+// they are logged and dropped.
+func WithEventErrorHandler(handle func(msg *nats.Msg, err error)) Option {
+	return func(o *options) { o.onEventError = handle }
+}
+
+// New returns a StockClient that sends requests over nc
+func New(nc *nats.Conn, opts ...Option) (*StockClient, error) {
+	if nc == nil {
+		return nil, errors.New("NATS connection is nil")
+	}
+	o := options{
+		timeout:  DefaultTimeout,
+		attempts: 1,
+		version:  "v1",
+		headers:  nats.Header{},
+		onEventError: func(msg *nats.Msg, err error) {
+			slog.Warn("Failed to decode message", "subject", msg.Subject, "error", err)
+		},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &StockClient{nc: nc, opts: o}
+	if o.validate {
+		v, err := newValidator()
+		if err != nil {
+			return nil, err
+		}
+		c.validator = v
+	}
+	return c, nil
+}
+
+// Add adds quantity units to the stock of the product, and returns its new stock level
+func (c *StockClient) Add(ctx context.Context, sku string, quantity int) (schemas.StockLevel, error) {
+	resp := schemas.StockAddResponse{}
+	req := schemas.StockAddRequest{ProductSKU: sku, Quantity: quantity}
+	if err := c.call(ctx, "add", schemas.StockAddRequestSchema, req, &resp); err != nil {
+		return schemas.StockLevel{}, err
+	}
+	return stockLevel(resp.ProductSKU, resp.Quantity), nil
+}
+
+// Remove removes quantity units from the stock of the product, and returns its new stock level. It fails
+// with ErrInsufficientStock when there are fewer units in stock.
+func (c *StockClient) Remove(ctx context.Context, sku string, quantity int) (schemas.StockLevel, error) {
+	resp := schemas.StockRemoveResponse{}
+	req := schemas.StockRemoveRequest{ProductSKU: sku, Quantity: quantity}
+	if err := c.call(ctx, "remove", schemas.StockRemoveRequestSchema, req, &resp); err != nil {
+		return schemas.StockLevel{}, err
+	}
+	return stockLevel(resp.ProductSKU, resp.Quantity), nil
+}
+
+// Get returns the stock level of the product
+func (c *StockClient) Get(ctx context.Context, sku string) (schemas.StockLevel, error) {
+	resp := schemas.StockGetResponse{}
+	req := schemas.StockGetRequest{ProductSKU: sku}
+	if err := c.call(ctx, "get", schemas.StockGetRequestSchema, req, &resp); err != nil {
+		return schemas.StockLevel{}, err
+	}
+	return stockLevel(resp.ProductSKU, resp.Quantity), nil
+}
+
+// List returns a page of up to limit stock levels, in order of their SKU, starting after the SKU after.
+// A limit of zero uses the service's default. It returns the after of the next page, or "" when this is
+// the last page. The page may hold fewer stock levels than limit when the caller may not see every product.
+func (c *StockClient) List(ctx context.Context, after string, limit int) ([]schemas.StockLevel, string, error) {
+	resp := schemas.StockListResponse{}
+	req := schemas.StockListRequest{}
+	if after != "" {
+		req.After = &after
+	}
+	if limit > 0 {
+		req.Limit = &limit
+	}
+	if err := c.call(ctx, "list", schemas.StockListRequestSchema, req, &resp); err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if resp.NextAfter != nil {
+		next = *resp.NextAfter
+	}
+	return resp.StockLevels, next, nil
+}
+
+// Schemas describes the request and response schemas of an endpoint, eg: `stock.add`, or of every
+// endpoint when endpoint is empty
+func (c *StockClient) Schemas(ctx context.Context, endpoint string) ([]schemas.EndpointSchemas, error) {
+	resp := schemas.StockSchemaResponse{}
+	req := schemas.StockSchemaRequest{}
+	if endpoint != "" {
+		req.Endpoint = &endpoint
+	}
+	if err := c.call(ctx, "schema", schemas.StockSchemaRequestSchema, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Endpoints, nil
+}
+
+func stockLevel(sku *string, quantity *int) schemas.StockLevel {
+	level := schemas.StockLevel{}
+	if sku != nil {
+		level.ProductSKU = *sku
+	}
+	if quantity != nil {
+		level.Quantity = *quantity
+	}
+	return level
+}
+
+// subject returns the subject of an endpoint, eg: `stock.v1.add`
+func (c *StockClient) subject(endpoint string) string {
+	if c.opts.version == "" {
+		return stockGroup + "." + endpoint
+	}
+	return stockGroup + "." + c.opts.version + "." + endpoint
+}
+
+// call sends a request to the endpoint, retrying it as configured, and decodes a successful response
+// into resp. A failed response is returned as an *Error.
+func (c *StockClient) call(ctx context.Context, endpoint, requestSchema string, req, resp any) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if c.validator != nil {
+		if err := c.validator.validate(requestSchema, data); err != nil {
+			return err
+		}
+	}
+	backoff := c.opts.backoff
+	for attempt := 1; ; attempt++ {
+		msg, err := c.request(ctx, c.subject(endpoint), data)
+		if err == nil {
+			err = decodeResponse(msg, resp)
+		}
+		if err == nil || attempt >= c.opts.attempts || !IsRetryable(err) {
+			return err
+		}
+		wait := max(backoff, retryAfter(msg))
+		backoff *= 2
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// request sends one request, telling the service how long the client will wait for the response
+func (c *StockClient) request(ctx context.Context, subject string, data []byte) (*nats.Msg, error) {
+	if _, ok := ctx.Deadline(); !ok && c.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	for key, values := range c.opts.headers {
+		msg.Header[key] = values
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline).Round(time.Millisecond); remaining > 0 {
+			msg.Header.Set(RequestTimeoutHeader, remaining.String())
+		}
+	}
+	return c.nc.RequestMsgWithContext(ctx, msg)
+}
+
+// decodeResponse decodes a successful response into resp, or returns the error of a failed one
+func decodeResponse(msg *nats.Msg, resp any) error {
+	failed := schemas.ErrorResponse{}
+	if err := json.Unmarshal(msg.Data, &failed); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadResponse, err)
+	}
+	if !failed.OK {
+		return errorFrom(&failed)
+	}
+	if err := json.Unmarshal(msg.Data, resp); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadResponse, err)
+	}
+	return nil
+}
+
+// retryAfter returns how long a rate limited response asks the client to wait
+func retryAfter(msg *nats.Msg) time.Duration {
+	if msg == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(msg.Header.Get(RetryAfterHeader))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
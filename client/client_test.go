@@ -0,0 +1,249 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connect starts a NATS server for the test, and returns a connection to it
+func connect(t *testing.T) *nats.Conn {
+	t.Helper()
+	s := natsserver.RunRandClientPortServer()
+	t.Cleanup(s.Shutdown)
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// serve replies to each request on subject with the response that reply returns
+func serve(t *testing.T, nc *nats.Conn, subject string, reply func(msg *nats.Msg) any) {
+	t.Helper()
+	sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		data, err := json.Marshal(reply(msg))
+		if err != nil {
+			panic(err)
+		}
+		_ = msg.Respond(data)
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+}
+
+func failed(code schemas.ErrorCode, message string) *schemas.ErrorResponse {
+	return &schemas.ErrorResponse{Error: &message, ErrorDetail: &schemas.ErrorDetail{Code: code, Message: message, Type: schemas.ErrorTypeCaller}}
+}
+
+func TestAdd(t *testing.T) {
+	nc := connect(t)
+	serve(t, nc, "stock.v1.add", func(msg *nats.Msg) any {
+		assert.Equal(t, "tenant-a", msg.Header.Get("Beaker-Tenant"))
+		timeout, err := time.ParseDuration(msg.Header.Get(RequestTimeoutHeader))
+		assert.NoError(t, err)
+		assert.InDelta(t, DefaultTimeout, timeout, float64(time.Second))
+		req := schemas.StockAddRequest{}
+		assert.NoError(t, json.Unmarshal(msg.Data, &req))
+		quantity := req.Quantity + 10
+		return schemas.StockAddResponse{OK: true, ProductSKU: &req.ProductSKU, Quantity: &quantity}
+	})
+	stock, err := New(nc, WithHeader("Beaker-Tenant", "tenant-a"))
+	require.NoError(t, err)
+
+	level, err := stock.Add(t.Context(), "coffee-cup", 5)
+	require.NoError(t, err)
+	assert.Equal(t, schemas.StockLevel{ProductSKU: "coffee-cup", Quantity: 15}, level)
+}
+
+func TestErrorResponse(t *testing.T) {
+	nc := connect(t)
+	serve(t, nc, "stock.v1.remove", func(msg *nats.Msg) any {
+		return failed(schemas.ErrorCodeInsufficientStock, "insufficient stock: 1 in stock")
+	})
+	stock, err := New(nc, WithRetries(3, time.Millisecond))
+	require.NoError(t, err)
+
+	_, err = stock.Remove(t.Context(), "coffee-cup", 2)
+	require.ErrorIs(t, err, ErrInsufficientStock)
+	assert.False(t, IsRetryable(err))
+	var stockErr *Error
+	require.True(t, errors.As(err, &stockErr))
+	assert.Equal(t, schemas.ErrorTypeCaller, stockErr.Type)
+	assert.Equal(t, "insufficient stock: 1 in stock", stockErr.Error())
+
+	// Responses from older services only carry a message
+	legacy := errorFrom(&schemas.ErrorResponse{Error: func() *string { s := "broken"; return &s }()})
+	assert.Equal(t, "broken", legacy.Error())
+	assert.Nil(t, legacy.Unwrap())
+}
+
+func TestRetries(t *testing.T) {
+	nc := connect(t)
+	var calls atomic.Int32
+	serve(t, nc, "stock.v1.get", func(msg *nats.Msg) any {
+		if calls.Add(1) == 1 {
+			return failed(schemas.ErrorCodeOverloaded, "overloaded")
+		}
+		sku, quantity := "coffee-cup", 3
+		return schemas.StockGetResponse{OK: true, ProductSKU: &sku, Quantity: &quantity}
+	})
+
+	// Without retries the first failure is returned
+	stock, err := New(nc)
+	require.NoError(t, err)
+	_, err = stock.Get(t.Context(), "coffee-cup")
+	require.ErrorIs(t, err, ErrOverloaded)
+
+	calls.Store(0)
+	stock, err = New(nc, WithRetries(2, time.Millisecond))
+	require.NoError(t, err)
+	level, err := stock.Get(t.Context(), "coffee-cup")
+	require.NoError(t, err)
+	assert.Equal(t, 3, level.Quantity)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestList(t *testing.T) {
+	nc := connect(t)
+	serve(t, nc, "stock.v1.list", func(msg *nats.Msg) any {
+		req := schemas.StockListRequest{}
+		assert.NoError(t, json.Unmarshal(msg.Data, &req))
+		if req.After == nil {
+			assert.Equal(t, 1, *req.Limit)
+			return schemas.StockListResponse{OK: true, StockLevels: []schemas.StockLevel{{ProductSKU: "coaster", Quantity: 2}}, NextAfter: utility.Ptr("coaster")}
+		}
+		assert.Equal(t, "coaster", *req.After)
+		return schemas.StockListResponse{OK: true, StockLevels: []schemas.StockLevel{{ProductSKU: "coffee-cup", Quantity: 5}}}
+	})
+	stock, err := New(nc)
+	require.NoError(t, err)
+
+	levels, next, err := stock.List(t.Context(), "", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []schemas.StockLevel{{ProductSKU: "coaster", Quantity: 2}}, levels)
+	assert.Equal(t, "coaster", next)
+
+	levels, next, err = stock.List(t.Context(), next, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []schemas.StockLevel{{ProductSKU: "coffee-cup", Quantity: 5}}, levels)
+	assert.Empty(t, next)
+}
+
+func TestTimeoutNotRetried(t *testing.T) {
+	nc := connect(t)
+	var calls atomic.Int32
+	serve(t, nc, "stock.v1.add", func(msg *nats.Msg) any {
+		calls.Add(1)
+		return failed(schemas.ErrorCodeTimeout, "context deadline exceeded")
+	})
+	stock, err := New(nc, WithRetries(3, time.Millisecond))
+	require.NoError(t, err)
+
+	// The add may have been committed, so sending it again could add the stock twice
+	_, err = stock.Add(t.Context(), "coffee-cup", 1)
+	require.ErrorIs(t, err, ErrTimeout)
+	assert.False(t, IsRetryable(err))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestValidation(t *testing.T) {
+	nc := connect(t)
+	stock, err := New(nc, WithValidation())
+	require.NoError(t, err)
+
+	// The request fails before it is sent, there is no service to answer it
+	_, err = stock.Add(t.Context(), "not a sku!", 0)
+	require.ErrorIs(t, err, ErrValidationFailed)
+
+	_, err = stock.Add(t.Context(), "coffee-cup", 1)
+	require.ErrorIs(t, err, nats.ErrNoResponders)
+	assert.True(t, IsRetryable(err))
+}
+
+func TestAPIVersion(t *testing.T) {
+	nc := connect(t)
+	serve(t, nc, "stock.get", func(msg *nats.Msg) any {
+		sku, quantity := "coffee-cup", 1
+		return schemas.StockGetResponse{OK: true, ProductSKU: &sku, Quantity: &quantity}
+	})
+	stock, err := New(nc, WithAPIVersion(""))
+	require.NoError(t, err)
+	_, err = stock.Get(t.Context(), "coffee-cup")
+	require.NoError(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	nc := connect(t)
+	serve(t, nc, "stock.v1.watch", func(msg *nats.Msg) any {
+		req := schemas.StockWatchRequest{}
+		assert.NoError(t, json.Unmarshal(msg.Data, &req))
+		assert.Equal(t, 2, *req.LeaseSeconds)
+		data, _ := json.Marshal(schemas.StockWatchUpdate{WatchID: "w1", ProductSKU: "coffee-cup", Quantity: 7})
+		assert.NoError(t, nc.Publish(req.Inbox, data))
+		id, expires := "w1", time.Now().Add(2*time.Second)
+		return schemas.StockWatchResponse{OK: true, WatchID: &id, LeaseExpiresAt: &expires, StockLevels: []schemas.StockLevel{}}
+	})
+	serve(t, nc, "stock.v1.watch.cancel", func(msg *nats.Msg) any {
+		id := "w1"
+		return schemas.StockWatchCancelResponse{OK: true, WatchID: &id}
+	})
+	stock, err := New(nc)
+	require.NoError(t, err)
+
+	updates := make(chan schemas.StockWatchUpdate, 1)
+	watch, err := stock.Watch(t.Context(), []string{"coffee-cup"}, 1500*time.Millisecond, func(u schemas.StockWatchUpdate) { updates <- u })
+	require.NoError(t, err)
+	assert.Equal(t, "w1", watch.ID)
+	select {
+	case u := <-updates:
+		assert.Equal(t, 7, u.Quantity)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no watch update")
+	}
+	require.NoError(t, watch.Cancel(t.Context()))
+	assert.False(t, watch.sub.IsValid())
+}
+
+func TestSubscribeStockChanged(t *testing.T) {
+	nc := connect(t)
+	stock, err := New(nc)
+	require.NoError(t, err)
+
+	events := make(chan schemas.StockChangedEvent, 2)
+	sub, err := stock.SubscribeStockChanged(AllTenants, "coffee-cup", func(e schemas.StockChangedEvent) { events <- e })
+	require.NoError(t, err)
+	defer sub.Unsubscribe() // nolint:errcheck
+
+	publish := func(eventType string, event schemas.StockChangedEvent) {
+		msg := nats.NewMsg(event.Subject())
+		msg.Header.Set(CloudEventTypeHeader, eventType)
+		msg.Data, _ = json.Marshal(event)
+		require.NoError(t, nc.PublishMsg(msg))
+	}
+	// A newer version of the event is skipped
+	publish("com.github.davidoram.beaker.stock-changed.v2", schemas.StockChangedEvent{TenantID: "tenant-a", ProductSKU: "coffee-cup", NewLevel: 1})
+	publish(schemas.StockChangedEventType, schemas.StockChangedEvent{TenantID: "tenant-a", ProductSKU: "coffee-cup", NewLevel: 2})
+
+	select {
+	case e := <-events:
+		assert.Equal(t, "tenant-a", e.TenantID)
+		assert.Equal(t, 2, e.NewLevel)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+}
+
+func TestLeaseSeconds(t *testing.T) {
+	assert.Nil(t, leaseSeconds(0))
+	assert.Equal(t, 1, *leaseSeconds(time.Millisecond))
+	assert.Equal(t, 60, *leaseSeconds(time.Minute))
+}
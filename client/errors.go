@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go"
+)
+
+// The errors of each error code. An *Error wraps the error of its code, so callers can check for one
+// with errors.Is, eg: errors.Is(err, client.ErrInsufficientStock).
+var (
+	ErrValidationFailed  = errors.New("validation failed")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrNotFound          = errors.New("not found")
+	ErrConflict          = errors.New("conflict")
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrForbidden         = errors.New("forbidden")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrTimeout           = errors.New("timeout")
+	ErrOverloaded        = errors.New("overloaded")
+	ErrUnavailable       = errors.New("unavailable")
+	ErrInvalidRequest    = errors.New("invalid request")
+	ErrInternal          = errors.New("internal error")
+
+	// ErrBadResponse means the service replied with something that isn't a response
+	ErrBadResponse = errors.New("bad response")
+)
+
+var codeErrors = map[schemas.ErrorCode]error{
+	schemas.ErrorCodeValidationFailed:  ErrValidationFailed,
+	schemas.ErrorCodeInsufficientStock: ErrInsufficientStock,
+	schemas.ErrorCodeNotFound:          ErrNotFound,
+	schemas.ErrorCodeConflict:          ErrConflict,
+	schemas.ErrorCodeUnauthorized:      ErrUnauthorized,
+	schemas.ErrorCodeForbidden:         ErrForbidden,
+	schemas.ErrorCodeRateLimited:       ErrRateLimited,
+	schemas.ErrorCodeTimeout:           ErrTimeout,
+	schemas.ErrorCodeOverloaded:        ErrOverloaded,
+	schemas.ErrorCodeUnavailable:       ErrUnavailable,
+	schemas.ErrorCodeInvalidRequest:    ErrInvalidRequest,
+	schemas.ErrorCodeInternal:          ErrInternal,
+}
+
+// Error is an error response from the stock service, or a request that failed validation before it was
+// sent. Use errors.As to read its detail.
+type Error struct {
+	Code    schemas.ErrorCode
+	Type    schemas.ErrorType
+	Message string
+	// Violations holds the JSON pointers to the parts of the request that broke the request schema
+	Violations []string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error of the code, or nil for a code this client doesn't know
+func (e *Error) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// errorFrom returns the error in a failed response. Responses from services older than the error detail
+// only carry a message, so they have no code.
+func errorFrom(resp *schemas.ErrorResponse) *Error {
+	e := &Error{Message: "request failed"}
+	if resp.Error != nil {
+		e.Message = *resp.Error
+	}
+	if d := resp.ErrorDetail; d != nil {
+		e.Code = d.Code
+		e.Type = d.Type
+		e.Message = d.Message
+		e.Violations = d.Violations
+	}
+	return e
+}
+
+// IsRetryable reports whether the request failed without the service changing anything, so it is safe
+// to send again, even for requests that change the inventory. A request that timed out isn't retryable,
+// whether it got no reply or a timeout error, as the service may still have made the change: adding and
+// removing stock aren't idempotent, so a second attempt could apply the change twice.
+func IsRetryable(err error) bool {
+	return errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, ErrOverloaded) ||
+		errors.Is(err, ErrUnavailable) ||
+		errors.Is(err, ErrRateLimited)
+}
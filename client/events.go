@@ -0,0 +1,53 @@
+package client
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go"
+)
+
+// CloudEventTypeHeader holds the CloudEvents type of an event, the service publishes every event with it
+const CloudEventTypeHeader = "ce-type"
+
+// AllTenants subscribes to the events of every tenant the connection is allowed to see
+const AllTenants = "*"
+
+// SubscribeLowStock calls handle with each LowStockEvent of the tenant, or of every tenant with AllTenants
+func (c *StockClient) SubscribeLowStock(tenant string, handle func(schemas.LowStockEvent)) (*nats.Subscription, error) {
+	prefix := schemas.LowStockEvent{}.Subject()
+	return Subscribe(c, schemas.LowStockEvent{TenantID: tenant}.Subject(), func(event schemas.LowStockEvent, msg *nats.Msg) {
+		event.TenantID = strings.TrimPrefix(msg.Subject, prefix)
+		handle(event)
+	})
+}
+
+// SubscribeStockChanged calls handle with each StockChangedEvent of the product and tenant. Pass AllTenants
+// or an sku of "*" to receive the changes of every tenant or product.
+func (c *StockClient) SubscribeStockChanged(tenant, sku string, handle func(schemas.StockChangedEvent)) (*nats.Subscription, error) {
+	subject := schemas.StockChangedEvent{TenantID: tenant, ProductSKU: sku}.Subject()
+	return Subscribe(c, subject, func(event schemas.StockChangedEvent, msg *nats.Msg) {
+		event.TenantID, _, _ = schemas.ParseStockChangedSubject(msg.Subject)
+		handle(event)
+	})
+}
+
+// Subscribe calls handle with each event of type E published on subject, which may hold wildcards. The
+// tenant of an event is carried in its subject rather than its payload, so handle is passed the message
+// to read it from. Events of another type on the same subject, eg: a newer version of E, are skipped.
+func Subscribe[E schemas.Event](c *StockClient, subject string, handle func(event E, msg *nats.Msg)) (*nats.Subscription, error) {
+	var zero E
+	eventType := zero.Type()
+	return c.nc.Subscribe(subject, func(msg *nats.Msg) {
+		if t := msg.Header.Get(CloudEventTypeHeader); t != "" && t != eventType {
+			return
+		}
+		var event E
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			c.opts.onEventError(msg, err)
+			return
+		}
+		handle(event, msg)
+	})
+}
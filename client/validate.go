@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// requestSchemas are the schemas of the requests the client sends
+var requestSchemas = []string{
+	schemas.StockAddRequestSchema,
+	schemas.StockRemoveRequestSchema,
+	schemas.StockGetRequestSchema,
+	schemas.StockListRequestSchema,
+	schemas.StockWatchRequestSchema,
+	schemas.StockWatchRenewRequestSchema,
+	schemas.StockWatchCancelRequestSchema,
+	schemas.StockSchemaRequestSchema,
+}
+
+// validator checks requests against their schemas before they are sent, so a bad request fails without
+// a round trip to the service. It uses the schemas built into the client, so a service running a newer
+// version of a schema may accept requests the client rejects.
+type validator struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// embeddedLoader loads the schemas built into the schemas package
+type embeddedLoader struct{}
+
+func (embeddedLoader) Load(url string) (any, error) {
+	return schemas.Document(url)
+}
+
+func newValidator() (*validator, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(embeddedLoader{})
+	compiler.AssertFormat()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	v := &validator{schemas: map[string]*jsonschema.Schema{}}
+	var errs []error
+	for _, id := range requestSchemas {
+		schema, err := compiler.Compile(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to compile schema %s: %w", id, err))
+			continue
+		}
+		v.schemas[id] = schema
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// validate returns an *Error with the validation_failed code when the request doesn't conform to its schema
+func (v *validator) validate(id string, data []byte) error {
+	schema, ok := v.schemas[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", schemas.ErrUnknownSchema, id)
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := schema.Validate(inst); err != nil {
+		return &Error{
+			Code:    schemas.ErrorCodeValidationFailed,
+			Type:    schemas.ErrorTypeCaller,
+			Message: err.Error(),
+		}
+	}
+	return nil
+}
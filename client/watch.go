@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go"
+)
+
+// Watch is a watch on the stock levels of some products. Its lease must be renewed before ExpiresAt, or
+// the service ends it. A Watch isn't safe for concurrent use.
+type Watch struct {
+	// ID identifies the watch to the service
+	ID string
+	// ExpiresAt is when the watch ends unless it is renewed
+	ExpiresAt time.Time
+	// Levels holds the stock level of each product when the watch started
+	Levels []schemas.StockLevel
+
+	client *StockClient
+	sub    *nats.Subscription
+}
+
+// Watch starts watching the stock levels of the products. handle is called with each change, one at a
+// time, until the watch is cancelled or its lease ends. A lease of zero uses the service's default.
+func (c *StockClient) Watch(ctx context.Context, skus []string, lease time.Duration, handle func(schemas.StockWatchUpdate)) (*Watch, error) {
+	inbox := c.nc.NewInbox()
+	sub, err := c.nc.Subscribe(inbox, func(msg *nats.Msg) {
+		update := schemas.StockWatchUpdate{}
+		if err := json.Unmarshal(msg.Data, &update); err != nil {
+			c.opts.onEventError(msg, err)
+			return
+		}
+		handle(update)
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := schemas.StockWatchResponse{}
+	req := schemas.StockWatchRequest{ProductSKUs: skus, Inbox: inbox, LeaseSeconds: leaseSeconds(lease)}
+	if err := c.call(ctx, "watch", schemas.StockWatchRequestSchema, req, &resp); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+	w := &Watch{Levels: resp.StockLevels, client: c, sub: sub}
+	if resp.WatchID != nil {
+		w.ID = *resp.WatchID
+	}
+	if resp.LeaseExpiresAt != nil {
+		w.ExpiresAt = *resp.LeaseExpiresAt
+	}
+	return w, nil
+}
+
+// Renew extends the watch's lease from now. A lease of zero uses the service's default.
+func (w *Watch) Renew(ctx context.Context, lease time.Duration) error {
+	resp := schemas.StockWatchRenewResponse{}
+	req := schemas.StockWatchRenewRequest{WatchID: w.ID, LeaseSeconds: leaseSeconds(lease)}
+	if err := w.client.call(ctx, "watch.renew", schemas.StockWatchRenewRequestSchema, req, &resp); err != nil {
+		return err
+	}
+	if resp.LeaseExpiresAt != nil {
+		w.ExpiresAt = *resp.LeaseExpiresAt
+	}
+	return nil
+}
+
+// Cancel ends the watch. The updates stop even if the service can't be told, as the watch ends with its
+// lease anyway.
+func (w *Watch) Cancel(ctx context.Context) error {
+	resp := schemas.StockWatchCancelResponse{}
+	req := schemas.StockWatchCancelRequest{WatchID: w.ID}
+	err := w.client.call(ctx, "watch.cancel", schemas.StockWatchCancelRequestSchema, req, &resp)
+	return errors.Join(err, w.sub.Unsubscribe())
+}
+
+// leaseSeconds converts a lease to whole seconds, rounding up, or nil for the service's default
+func leaseSeconds(lease time.Duration) *int {
+	if lease <= 0 {
+		return nil
+	}
+	seconds := int((lease + time.Second - 1) / time.Second)
+	return &seconds
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/davidoram/beaker/internal/export"
+	"golang.org/x/exp/slog"
+)
+
+// runExport implements the `beaker export` subcommand, which writes a snapshot of the
+// inventory table to stdout or a file
+func runExport(args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setTimeZoneToUTCOrExit()
+	opts := getExportOptionsOrExit(args)
+	pool := setupPostgresPoolOrExit(ctx, opts.PostgresURL)
+	defer pool.Close()
+	compiler := makeJSONSchemaCompilerOrExit(ctx, opts.SchemaDir)
+	setupSignalHandler(ctx, cancel)
+
+	out, closeOut := openExportOutputOrExit(ctx, opts.OutputFile)
+	count, err := export.Run(ctx, pool, compiler, out, export.Options{
+		Tenant:   opts.Tenant,
+		Format:   opts.Format,
+		Columns:  opts.Columns,
+		PageSize: opts.PageSize,
+	})
+	if err == nil {
+		err = closeOut()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Export failed", "error", err, "rows", count)
+		pool.Close()
+		os.Exit(1)
+	}
+	slog.InfoContext(ctx, "Export complete", "rows", count, "format", opts.Format, "output", opts.OutputFile)
+}
+
+func getExportOptionsOrExit(args []string) ExportOptions {
+	opts, err := ParseExportOptions(args)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Error parsing export options: " + err.Error() + "\n")
+		os.Exit(1)
+	}
+	return opts
+}
+
+// openExportOutputOrExit opens the export destination, '-' means stdout. The returned function
+// flushes any buffered output and closes the destination.
+func openExportOutputOrExit(ctx context.Context, outputFile string) (io.Writer, func() error) {
+	if outputFile == "-" {
+		buffered := bufio.NewWriter(os.Stdout)
+		return buffered, buffered.Flush
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to create export output file", "error", err)
+		os.Exit(1)
+	}
+	buffered := bufio.NewWriter(f)
+	return buffered, func() error {
+		if err := buffered.Flush(); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("closing %s: %w", outputFile, err)
+		}
+		return nil
+	}
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/davidoram/beaker/internal/api"
+	"github.com/davidoram/beaker/internal/authz"
+	"github.com/davidoram/beaker/internal/concurrency"
+	"github.com/davidoram/beaker/internal/ratelimit"
 	"github.com/davidoram/beaker/internal/telemetry"
 	"github.com/davidoram/beaker/internal/utility"
 	"github.com/exaring/otelpgx"
@@ -19,31 +26,114 @@ import (
 )
 
 func main() {
+	// Subcommands are selected by the first argument. Flags without a subcommand run the service, as
+	// beaker did before it had subcommands.
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runServe(args)
+		return
+	}
+	switch args[0] {
+	case "serve":
+		runServe(args[1:])
+	case "export":
+		runExport(args[1:])
+	case "stock":
+		runStock(args[1:])
+	default:
+		_, _ = os.Stderr.WriteString("Unknown subcommand " + args[0] + ", must be one of serve, export or stock\n")
+		os.Exit(1)
+	}
+}
+
+// runServe implements the `beaker serve` subcommand, which runs the service until it is signalled to stop
+func runServe(args []string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	setTimeZoneToUTCOrExit()
-	opts := getOptionsOrExit()
+	opts := getOptionsOrExit(args)
 	closeOtel := setupTelemetryOrExit(ctx, opts)
-	defer closeOtel()
 	pool := setupPostgresPoolOrExit(ctx, opts.PostgresURL)
-	defer pool.Close()
 	nc := connectToNATSOrExit(ctx, opts.NatsURL, opts.CredentialsFile)
-	defer nc.Close()
 	compiler := makeJSONSchemaCompilerOrExit(ctx, opts.SchemaDir)
 	setupSignalHandler(ctx, cancel)
-	app := startAppOrExit(nc, pool, compiler)
-	defer app.Stop() // nolint:errcheck
+	authorizer := setupAuthorizerOrExit(ctx, opts.PolicyFile)
+	app := startAppOrExit(nc, pool, compiler, api.Config{
+		TenantHeader:   opts.TenantHeader,
+		GatewayAccount: opts.GatewayAccount,
+		Authorizer:     authorizer,
+		RateLimiter:    makeRateLimiter(ctx, opts.RateLimits),
+
+		ResponseValidationRate: opts.ResponseValidationRate,
+		MaxRequestTimeout:      opts.MaxRequestTimeout,
+		Concurrency:            makeConcurrencyLimiter(ctx, opts.Concurrency),
+		MaxHandlers:            opts.MaxHandlers,
+		SKUPolicy:              opts.SKUPolicy,
+		WatchInboxPrefix:       opts.WatchInboxPrefix,
+	})
+	var closeListeners []func(context.Context)
+	if opts.HTTPAddr != "" {
+		closeListeners = append(closeListeners, startHTTPServerOrExit(ctx, opts.HTTPAddr, opts.HTTPTokensFile, app))
+	}
+	if opts.HealthAddr != "" {
+		closeListeners = append(closeListeners, startHealthServerOrExit(ctx, opts.HealthAddr, app))
+	}
 	slog.InfoContext(ctx, "beaker is running")
 
 	// Wait for the context to be cancelled
 	<-ctx.Done()
-	slog.InfoContext(ctx, "shutting down application")
+	slog.InfoContext(ctx, "shutting down application", "grace", opts.ShutdownGrace)
+	shutdown(opts.ShutdownGrace, app, closeListeners, nc, pool, closeOtel)
 }
 
-func getOptionsOrExit() Options {
+// shutdown stops beaker in a fixed order, so a request in flight never loses the connections it is
+// using. Every step shares the grace period, once it has passed the remaining steps don't wait.
+// The HTTP listeners stay open while the requests in flight finish, so /readyz reports not ready.
+func shutdown(grace time.Duration, app *api.App, closeListeners []func(context.Context), nc *nats.Conn, pool *pgxpool.Pool, closeOtel func(context.Context)) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// Stop accepting requests, and wait for the requests in flight to finish
+	if err := app.Shutdown(ctx); err != nil {
+		slog.ErrorContext(ctx, "Requests did not finish before shutdown", "error", err)
+	}
+	for _, closeListener := range closeListeners {
+		closeListener(ctx)
+	}
+
+	// Flush the events published by the last requests, and drain the NATS connection
+	if err := nc.FlushWithContext(ctx); err != nil {
+		slog.ErrorContext(ctx, "Unable to flush NATS connection", "error", err)
+	}
+	if err := nc.Drain(); err != nil {
+		slog.ErrorContext(ctx, "Unable to drain NATS connection", "error", err)
+	}
+	waitForClose(ctx, nc)
+
+	pool.Close()
+	closeOtel(ctx)
+	slog.Info("beaker has shut down")
+}
+
+// waitForClose waits until a draining NATS connection has closed, or ctx is done
+func waitForClose(ctx context.Context, nc *nats.Conn) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !nc.IsClosed() {
+		select {
+		case <-ctx.Done():
+			slog.ErrorContext(ctx, "NATS connection did not drain before shutdown")
+			nc.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func getOptionsOrExit(args []string) Options {
 	// Parse command line arguments to get options
-	opts, err := GetOptions()
+	opts, err := ParseOptions(args)
 	if err != nil {
 		// If options parsing fails, print the error and exit with an error code
 		_, _ = os.Stderr.WriteString("Error parsing options: " + err.Error() + "\n")
@@ -60,14 +150,15 @@ func setTimeZoneToUTCOrExit() {
 	}
 }
 
-func setupTelemetryOrExit(ctx context.Context, opts Options) func() {
+func setupTelemetryOrExit(ctx context.Context, opts Options) func(context.Context) {
 	// Initialize telemetry
-	shutdown, err := telemetry.NewTelemetryProvider(ctx, "beaker")
+	shutdownTelemetry, err := telemetry.NewTelemetryProvider(ctx, "beaker")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize telemetry: %v\n", err)
 		os.Exit(1)
 	}
-	return func() { _ = shutdown(ctx) }
+	// The shutdown gets its own context, ctx has been cancelled by the time the telemetry is flushed
+	return func(ctx context.Context) { _ = shutdownTelemetry(ctx) }
 }
 
 func setupPostgresPoolOrExit(ctx context.Context, postgresUrl string) *pgxpool.Pool {
@@ -130,6 +221,81 @@ func makeJSONSchemaCompilerOrExit(ctx context.Context, schemaDir string) *jsonsc
 	return compiler
 }
 
+// setupAuthorizerOrExit loads the authorization policy, and watches it for changes.
+// It returns nil if there is no policy file, so every caller may use every endpoint.
+func setupAuthorizerOrExit(ctx context.Context, policyFile string) *authz.Authorizer {
+	if policyFile == "" {
+		slog.WarnContext(ctx, "no authorization policy file, every caller may use every endpoint")
+		return nil
+	}
+	authorizer, err := authz.NewFileAuthorizer(policyFile)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to load authorization policy", "error", err)
+		os.Exit(1)
+	}
+	authorizer.Watch(ctx, authz.DefaultReloadInterval)
+	return authorizer
+}
+
+// startHTTPServerOrExit serves the API over HTTP, and returns a function that shuts the server down
+func startHTTPServerOrExit(ctx context.Context, addr, tokensFile string, app *api.App) func(context.Context) {
+	tokens, err := api.LoadBearerTokens(tokensFile)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to load HTTP bearer tokens", "error", err)
+		os.Exit(1)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to start HTTP listener", "error", err)
+		os.Exit(1)
+	}
+	return serveHTTP(ctx, listener, app.HTTPHandler(tokens))
+}
+
+// startHealthServerOrExit serves the health checks over HTTP, and returns a function that shuts the server down
+func startHealthServerOrExit(ctx context.Context, addr string, app *api.App) func(context.Context) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.ErrorContext(ctx, "Unable to start health listener", "error", err)
+		os.Exit(1)
+	}
+	return serveHTTP(ctx, listener, app.HealthHandler())
+}
+
+// serveHTTP serves requests on the listener in the background, and returns a function that shuts the server down
+func serveHTTP(ctx context.Context, listener net.Listener, handler http.Handler) func(context.Context) {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.ErrorContext(ctx, "HTTP server failed", "error", err, "addr", listener.Addr().String())
+		}
+	}()
+	slog.InfoContext(ctx, "HTTP listener started", "addr", listener.Addr().String())
+	return func(ctx context.Context) {
+		_ = server.Shutdown(ctx)
+	}
+}
+
+// makeRateLimiter returns a rate limiter that applies the limits, or nil if there are no limits
+func makeRateLimiter(ctx context.Context, limits ratelimit.Config) *ratelimit.Limiter {
+	if limits.Default == nil && len(limits.Endpoints) == 0 {
+		slog.WarnContext(ctx, "no rate limits, callers may make as many requests as they like")
+		return nil
+	}
+	return ratelimit.NewLimiter(limits)
+}
+
+func makeConcurrencyLimiter(ctx context.Context, config concurrency.Config) *concurrency.Limiter {
+	if !config.Enabled() {
+		slog.WarnContext(ctx, "no concurrency caps, requests may queue for a database connection")
+		return nil
+	}
+	return concurrency.NewLimiter(config)
+}
+
 func setupSignalHandler(ctx context.Context, cancel context.CancelFunc) {
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
@@ -137,12 +303,17 @@ func setupSignalHandler(ctx context.Context, cancel context.CancelFunc) {
 		<-signalCh // Receive signal but don't need to use it
 		slog.InfoContext(ctx, "Received termination signal, shutting down...")
 		cancel() // Cancel the context to signal graceful shutdown
+
+		// A second signal means the operator doesn't want to wait for the graceful shutdown
+		<-signalCh
+		slog.Warn("Received second termination signal, exiting immediately")
+		os.Exit(1)
 	}()
 }
 
-func startAppOrExit(nc *nats.Conn, pool *pgxpool.Pool, compiler *jsonschema.Compiler) *api.App {
+func startAppOrExit(nc *nats.Conn, pool *pgxpool.Pool, compiler *jsonschema.Compiler, config api.Config) *api.App {
 	// Create a new application instance
-	app, err := api.StartNewApp(nc, pool, compiler)
+	app, err := api.StartNewApp(nc, pool, compiler, config)
 	if err != nil {
 		slog.Error("Failed to create application instance", err)
 		os.Exit(1)
@@ -0,0 +1,29 @@
+// Command schemagen writes the Go types of the JSON schemas in a directory, see package schemagen.
+// It is run by `go generate ./schemas`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/davidoram/beaker/internal/schemagen"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "Directory holding the JSON schemas")
+	out := flag.String("out", "schemas_gen.go", "File to write the Go types to, relative to the directory")
+	pkg := flag.String("package", "schemas", "Package of the generated file")
+	flag.Parse()
+
+	src, err := schemagen.Generate(*dir, *pkg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "schemagen: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(filepath.Join(*dir, *out), src, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "schemagen: %v\n", err)
+		os.Exit(1)
+	}
+}
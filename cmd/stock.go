@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/davidoram/beaker/client"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go"
+)
+
+// runStock implements the `beaker stock` subcommands, which call the running service over NATS
+// and print the stock levels it returns
+func runStock(args []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := getStockOptionsOrExit(args)
+	nc := connectToServiceOrExit(opts)
+	defer nc.Close()
+	stock := newStockClientOrExit(nc, opts)
+	setupSignalHandler(ctx, cancel)
+
+	levels, err := callStock(ctx, stock, opts)
+	if err == nil {
+		err = printStockLevels(os.Stdout, opts.Format, levels, opts.Command == "list")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "beaker stock %s failed: %v\n", opts.Command, err)
+		nc.Close()
+		os.Exit(1)
+	}
+}
+
+func getStockOptionsOrExit(args []string) StockOptions {
+	opts, err := ParseStockOptions(args)
+	if err != nil {
+		_, _ = os.Stderr.WriteString("Error parsing stock options: " + err.Error() + "\n")
+		os.Exit(1)
+	}
+	return opts
+}
+
+// connectToServiceOrExit connects to NATS as a short lived client, so unlike the service it gives up
+// rather than waiting for the server to come back
+func connectToServiceOrExit(opts StockOptions) *nats.Conn {
+	natsOpts := []nats.Option{nats.Name("beaker stock"), nats.Timeout(opts.Timeout)}
+	if opts.CredentialsFile != "" {
+		natsOpts = append(natsOpts, nats.UserCredentials(opts.CredentialsFile))
+	}
+	nc, err := nats.Connect(opts.NatsURL, natsOpts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to NATS server %s: %v\n", opts.NatsURL, err)
+		os.Exit(1)
+	}
+	return nc
+}
+
+func newStockClientOrExit(nc *nats.Conn, opts StockOptions) *client.StockClient {
+	clientOpts := []client.Option{client.WithTimeout(opts.Timeout), client.WithAPIVersion(opts.APIVersion)}
+	if opts.Tenant != "" {
+		clientOpts = append(clientOpts, client.WithHeader(opts.TenantHeader, opts.Tenant))
+	}
+	stock, err := client.New(nc, clientOpts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create stock client: %v\n", err)
+		os.Exit(1)
+	}
+	return stock
+}
+
+// callStock calls the endpoint of the command, and returns the stock levels in the response. list
+// follows the pages until the last one, so it returns every product the caller may see.
+func callStock(ctx context.Context, stock *client.StockClient, opts StockOptions) ([]schemas.StockLevel, error) {
+	var level schemas.StockLevel
+	var err error
+	switch opts.Command {
+	case "add":
+		level, err = stock.Add(ctx, opts.SKU, opts.Quantity)
+	case "remove":
+		level, err = stock.Remove(ctx, opts.SKU, opts.Quantity)
+	case "get":
+		level, err = stock.Get(ctx, opts.SKU)
+	case "list":
+		levels := []schemas.StockLevel{}
+		after := opts.After
+		for {
+			page, next, err := stock.List(ctx, after, opts.PageSize)
+			if err != nil {
+				return nil, err
+			}
+			levels = append(levels, page...)
+			if next == "" {
+				return levels, nil
+			}
+			after = next
+		}
+	default:
+		return nil, ErrBadStockCommand
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []schemas.StockLevel{level}, nil
+}
+
+// printStockLevels writes the stock levels as a table or as JSON. The JSON of a list is an array, the
+// JSON of any other command is the one stock level it returns.
+func printStockLevels(w io.Writer, format OutputFormat, levels []schemas.StockLevel, list bool) error {
+	if format == FormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if !list && len(levels) == 1 {
+			return encoder.Encode(levels[0])
+		}
+		return encoder.Encode(levels)
+	}
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "PRODUCT SKU\tQUANTITY")
+	for _, level := range levels {
+		_, _ = fmt.Fprintf(table, "%s\t%d\n", level.ProductSKU, level.Quantity)
+	}
+	return table.Flush()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintStockLevels(t *testing.T) {
+	levels := []schemas.StockLevel{{ProductSKU: "coaster", Quantity: 2}, {ProductSKU: "coffee-cup", Quantity: 15}}
+
+	out := bytes.Buffer{}
+	require.NoError(t, printStockLevels(&out, FormatTable, levels, true))
+	require.Equal(t, "PRODUCT SKU  QUANTITY\ncoaster      2\ncoffee-cup   15\n", out.String())
+
+	out.Reset()
+	require.NoError(t, printStockLevels(&out, FormatJSON, levels[1:], false))
+	require.JSONEq(t, `{"product-sku": "coffee-cup", "quantity": 15}`, out.String())
+
+	// A list prints an array, even when it is empty
+	out.Reset()
+	require.NoError(t, printStockLevels(&out, FormatJSON, []schemas.StockLevel{}, true))
+	require.JSONEq(t, `[]`, out.String())
+}
@@ -0,0 +1,35 @@
+-- +migrate Up
+
+-- Existing rows belong to the 'default' tenant
+alter table inventory add column tenant_id varchar(64) not null default 'default';
+alter table inventory alter column tenant_id drop default;
+
+-- A product SKU is only unique within a tenant
+alter table inventory drop constraint inventory_pkey;
+alter table inventory add primary key (tenant_id, product_sku);
+
+-- Ensure tenant_id only contains characters that are safe to use in a NATS subject
+alter table inventory add constraint inventory_tenant_id_format
+    check (tenant_id ~ '^[A-Za-z0-9_-]+$');
+
+-- Row level security is a second line of defence behind the tenant_id in every query.
+-- Each transaction sets 'beaker.tenant_id', and can only see or change that tenant's rows.
+-- Note: superusers and roles with BYPASSRLS are not subject to these policies.
+alter table inventory enable row level security;
+alter table inventory force row level security;
+
+create policy inventory_tenant_isolation on inventory
+    using (tenant_id = current_setting('beaker.tenant_id', true))
+    with check (tenant_id = current_setting('beaker.tenant_id', true));
+
+
+-- +migrate Down
+
+drop policy inventory_tenant_isolation on inventory;
+alter table inventory no force row level security;
+alter table inventory disable row level security;
+alter table inventory drop constraint inventory_tenant_id_format;
+alter table inventory drop constraint inventory_pkey;
+delete from inventory where tenant_id <> 'default';
+alter table inventory add primary key (product_sku);
+alter table inventory drop column tenant_id;
@@ -0,0 +1,46 @@
+-- +migrate Up
+
+-- Barcodes, GTINs and legacy codes that callers may use in place of a product SKU
+create table sku_aliases (
+    tenant_id varchar(64) not null,
+    alias varchar(100) not null,
+    product_sku varchar(50) not null,
+    alias_type varchar(16) not null,
+
+    -- An alias is only unique within a tenant
+    primary key (tenant_id, alias),
+
+    -- Ensure tenant_id only contains characters that are safe to use in a NATS subject
+    constraint sku_aliases_tenant_id_format
+        check (tenant_id ~ '^[A-Za-z0-9_-]+$'),
+
+    -- Ensure an alias stands for a SKU in the same form as the inventory table keeps them
+    constraint sku_aliases_product_sku_format
+        check (product_sku ~ '^[a-z0-9_-]+$'),
+
+    -- Ensure an alias can be found once a caller's SKU is in canonical form, the same format as the
+    -- product SKUs. The service's SKU policy puts an alias into that form before it is stored.
+    constraint sku_aliases_alias_format
+        check (alias ~ '^[a-z0-9_-]+$'),
+
+    -- An alias that stood for itself would be pointless
+    constraint sku_aliases_not_self
+        check (alias <> product_sku),
+
+    constraint sku_aliases_alias_type
+        check (alias_type in ('barcode', 'gtin', 'legacy'))
+);
+
+-- Each tenant only sees its own aliases, the same as its inventory
+alter table sku_aliases enable row level security;
+alter table sku_aliases force row level security;
+
+create policy sku_aliases_tenant_isolation on sku_aliases
+    using (tenant_id = current_setting('beaker.tenant_id', true))
+    with check (tenant_id = current_setting('beaker.tenant_id', true));
+
+
+-- +migrate Down
+
+drop policy sku_aliases_tenant_isolation on sku_aliases;
+drop table sku_aliases;
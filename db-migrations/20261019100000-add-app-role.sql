@@ -0,0 +1,31 @@
+-- +migrate Up
+
+-- The service connects as beaker_app rather than as the owner of the tables. Superusers and roles with
+-- BYPASSRLS aren't subject to row level security, so beaker_app must never be either. Roles belong to the
+-- server rather than a database, so the role is shared by every database on the server.
+-- +migrate StatementBegin
+do $$
+begin
+    if not exists (select from pg_roles where rolname = 'beaker_app') then
+        create role beaker_app nosuperuser nobypassrls nocreatedb nocreaterole;
+    end if;
+end
+$$;
+-- +migrate StatementEnd
+
+grant usage on schema public to beaker_app;
+grant select, insert, update, delete on inventory, sku_aliases to beaker_app;
+
+-- The health checks read the newest migration
+grant select on migrations to beaker_app;
+
+-- Tables added by later migrations can be used without granting them one by one
+alter default privileges in schema public grant select, insert, update, delete on tables to beaker_app;
+
+
+-- +migrate Down
+
+alter default privileges in schema public revoke select, insert, update, delete on tables from beaker_app;
+revoke select on migrations from beaker_app;
+revoke select, insert, update, delete on inventory, sku_aliases from beaker_app;
+revoke usage on schema public from beaker_app;
@@ -0,0 +1,22 @@
+// Package migrations holds the database migrations, so the service can check the database it is connected
+// to has been migrated to the version it was built for. The migrations are applied with sql-migrate.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"slices"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Latest returns the ID of the newest migration, which is how sql-migrate records it in the migrations table.
+// Migration IDs start with a timestamp, so they sort in the order they are applied.
+func Latest() string {
+	ids, _ := fs.Glob(files, "*.sql")
+	if len(ids) == 0 {
+		return ""
+	}
+	return slices.Max(ids)
+}
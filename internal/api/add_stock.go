@@ -7,17 +7,21 @@ import (
 	"github.com/davidoram/beaker/internal/telemetry"
 	"github.com/davidoram/beaker/internal/utility"
 	"github.com/davidoram/beaker/schemas"
-	"github.com/nats-io/nats.go/micro"
 )
 
-func (app *App) stockAddHandler(ctx context.Context, req micro.Request) {
-	rs := NewRequestScope(ctx, req, app.nc, app.db)
-	defer rs.Close(ctx)
-	rs.ValidateJSON(ctx, app.compiler, req.Data(), schemas.StockAddRequestSchema)
-	stockReq := DecodeRequest[schemas.StockAddRequest](ctx, rs)
-	resp := rs.MakeStockAddResponse(ctx, rs.AddStock(ctx, stockReq))
-	rs.CommitOrRollback(ctx)
-	rs.RespondJSON(ctx, req, resp)
+// stockAdd handles the stock.add endpoint
+func (app *App) stockAdd(ctx context.Context, rs *requestScope, req schemas.StockAddRequest) *schemas.StockAddResponse {
+	req.ProductSKU = app.resolveSKU(ctx, rs, req.ProductSKU)
+	updatedInventory := rs.AddStock(ctx, req)
+	rs.EmitStockChangedEvent(ctx, app.schemas, schemas.StockOperationAdd, req.Quantity, updatedInventory)
+	if rs.HasError() {
+		return nil
+	}
+	return &schemas.StockAddResponse{
+		OK:         true,
+		ProductSKU: utility.Ptr(updatedInventory.ProductSku),
+		Quantity:   utility.Ptr(int(updatedInventory.StockLevel)),
+	}
 }
 
 // AddStock adds stock to the inventory.
@@ -31,6 +35,7 @@ func (rs *requestScope) AddStock(ctx context.Context, req schemas.StockAddReques
 	}
 
 	params := db.AddInventoryParams{
+		TenantID:   rs.tenant,
 		ProductSku: string(req.ProductSKU),
 		StockLevel: int32(req.Quantity),
 	}
@@ -41,20 +46,3 @@ func (rs *requestScope) AddStock(ctx context.Context, req schemas.StockAddReques
 	}
 	return &inventory
 }
-
-func (rs *requestScope) MakeStockAddResponse(ctx context.Context, inventory *db.Inventory) *schemas.StockAddResponse {
-	tracer := telemetry.GetTracer()
-	_, span := tracer.Start(ctx, "build stock-add response")
-	defer span.End()
-
-	resp := schemas.StockAddResponse{}
-	if rs.HasError() {
-		resp.OK = false
-		resp.Error = utility.Ptr(rs.GetError().Error())
-	} else {
-		resp.OK = true
-		resp.ProductSKU = utility.Ptr(inventory.ProductSku)
-		resp.Quantity = utility.Ptr(int(inventory.StockLevel))
-	}
-	return &resp
-}
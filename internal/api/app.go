@@ -3,8 +3,15 @@ package api
 import (
 	"context"
 	"log/slog"
+	"slices"
+	"time"
 
-	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/authz"
+	"github.com/davidoram/beaker/internal/concurrency"
+	"github.com/davidoram/beaker/internal/ratelimit"
+	"github.com/davidoram/beaker/internal/sku"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
@@ -13,33 +20,188 @@ import (
 
 // App represents the application context
 type App struct {
-	nc       *nats.Conn
-	db       *pgxpool.Pool
-	svc      micro.Service
-	compiler *jsonschema.Compiler
+	nc  *nats.Conn
+	db  *pgxpool.Pool
+	svc micro.Service
+	// schemas holds the compiled schema of every request, response and event
+	schemas *utility.SchemaRegistry
+	watches *watchRegistry
+	config  Config
+	metrics *appMetrics
+	// responses checks a sample of the responses against their schemas
+	responses *responseValidator
+	// requests tracks the requests in flight, so Shutdown can wait for them
+	requests *requestTracker
+	// handlers holds a slot for each NATS request being handled, see wrapHandler
+	handlers chan struct{}
 }
 
-func StartNewApp(nc *nats.Conn, db *pgxpool.Pool, compiler *jsonschema.Compiler) (*App, error) {
+// DefaultMaxHandlers is how many NATS requests are handled at once when Config.MaxHandlers isn't set
+const DefaultMaxHandlers = 256
 
+// Config holds the settings that change how the App behaves
+type Config struct {
+	// TenantHeader names the request header that a trusted gateway uses to pass the caller's tenant.
+	// Leave it empty to only take the tenant from the caller's NATS account.
+	TenantHeader string
+	// GatewayAccount is the NATS account of the trusted gateway. The TenantHeader is only honoured on
+	// requests that the Nats-Request-Info header says came from it.
+	GatewayAccount string
+
+	// Authorizer decides which endpoints and products each caller may use.
+	// Leave it nil to allow every caller to use every endpoint.
+	Authorizer *authz.Authorizer
+
+	// RateLimiter limits how often each caller may use each endpoint.
+	// Leave it nil to allow callers to make as many requests as they like.
+	RateLimiter *ratelimit.Limiter
+
+	// ResponseValidationRate is the fraction of responses that are checked against their schema,
+	// between 0 (no responses) and 1 (every response)
+	ResponseValidationRate float64
+
+	// MaxRequestTimeout is the longest a request may run for, callers can ask for less with the
+	// RequestTimeoutHeader. Leave it zero to let requests without the header run for as long as they take.
+	MaxRequestTimeout time.Duration
+
+	// Concurrency caps how many requests are handled at once, service wide and for each endpoint.
+	// Leave it nil to handle as many requests at once as arrive.
+	Concurrency *concurrency.Limiter
+
+	// MaxHandlers is how many requests that arrive over NATS are handled at once, each on its own
+	// goroutine. Once they are all busy NATS holds the next requests. It must be larger than the
+	// Concurrency caps and queues, so they can turn the surplus away. Leave it zero for DefaultMaxHandlers.
+	MaxHandlers int
+
+	// WatchInboxPrefix is the subject prefix that every watch inbox must start with, eg: the prefix set
+	// with nats.CustomInboxPrefix. Leave it empty to only allow inboxes made with NATS's default prefix,
+	// DefaultWatchInboxPrefix.
+	WatchInboxPrefix string
+
+	// SKUPolicy puts the product SKUs named in requests into canonical form, before their aliases are
+	// resolved. Leave it zero to use SKUs as they are sent.
+	SKUPolicy sku.Policy
+
+	// Middleware is added to the end of the chain that every request passes through, after the built in
+	// middleware has identified, authorized, and rate limited the caller
+	Middleware []Middleware
+}
+
+func StartNewApp(nc *nats.Conn, db *pgxpool.Pool, compiler *jsonschema.Compiler, config Config) (*App, error) {
+
+	metrics, err := newAppMetrics()
+	if err != nil {
+		return nil, err
+	}
+	if config.Concurrency != nil {
+		if err := observeQueueDepths(config.Concurrency); err != nil {
+			return nil, err
+		}
+	}
+	if config.MaxHandlers <= 0 {
+		config.MaxHandlers = DefaultMaxHandlers
+	}
 	app := &App{
 		nc:       nc,
 		db:       db,
-		compiler: compiler,
+		config:   config,
+		metrics:  metrics,
+		watches:  newWatchRegistry(nc, config.WatchInboxPrefix),
+		requests: newRequestTracker(),
+		handlers: make(chan struct{}, config.MaxHandlers),
+	}
+	// Compile every schema up front, so a broken schema stops the service starting rather than
+	// failing the first request that uses it
+	app.schemas, err = utility.NewSchemaRegistry(compiler, app.schemaIDs()...)
+	if err != nil {
+		return nil, err
+	}
+	app.responses = &responseValidator{
+		registry:   app.schemas,
+		sampleRate: config.ResponseValidationRate,
+		metrics:    metrics,
 	}
 	if err := app.makeService(); err != nil {
 		return nil, err
 	}
+	if err := app.watches.Start(); err != nil {
+		_ = app.svc.Stop()
+		return nil, err
+	}
 
 	return app, nil
 }
 
+// Stop stops the App at once, without waiting for the requests in flight, see Shutdown
 func (app *App) Stop() error {
 	if err := app.svc.Stop(); err != nil {
 		return err
 	}
+	app.watches.Stop()
 	return nil
 }
 
+// stockGroup prefixes the subjects of all the stock endpoints
+const stockGroup = "stock"
+
+// endpoint describes one of the stock endpoints. Endpoints can be reached over NATS, and over HTTP, on
+// the subjects of each API version that serves them, see routes.
+type endpoint struct {
+	// name identifies the endpoint in the service info and stats
+	name string
+	// subject is relative to the stock group and version
+	subject string
+	handler Handler
+	// middleware runs after the App's middleware, just before the handler
+	middleware []Middleware
+	// requestSchema and responseSchema are the IDs of the schemas the endpoint's requests and responses conform to
+	requestSchema  string
+	responseSchema string
+}
+
+// endpoints returns the endpoints of the first version of the API
+func (app *App) endpoints() []endpoint {
+	return []endpoint{
+		endpointDef[schemas.StockAddRequest, schemas.StockAddResponse]{name: "add", subject: "add", tx: readWriteTx,
+			requestSchema: schemas.StockAddRequestSchema, responseSchema: schemas.StockAddResponseSchema, handle: app.stockAdd}.endpoint(app),
+		endpointDef[schemas.StockRemoveRequest, schemas.StockRemoveResponse]{name: "remove", subject: "remove", tx: readWriteTx,
+			requestSchema: schemas.StockRemoveRequestSchema, responseSchema: schemas.StockRemoveResponseSchema, handle: app.stockRemove}.endpoint(app),
+		endpointDef[schemas.StockGetRequest, schemas.StockGetResponse]{name: "get", subject: "get", tx: readOnlyTx,
+			requestSchema: schemas.StockGetRequestSchema, responseSchema: schemas.StockGetResponseSchema, handle: app.stockGet}.endpoint(app),
+		endpointDef[schemas.StockListRequest, schemas.StockListResponse]{name: "list", subject: "list", tx: readOnlyTx,
+			requestSchema: schemas.StockListRequestSchema, responseSchema: schemas.StockListResponseSchema, handle: app.stockList}.endpoint(app),
+		// stock.watch cancels the watch it started when the request fails, after the transaction has ended,
+		// so it takes the request through the steps itself
+		{name: "watch", subject: "watch", handler: app.stockWatchHandler,
+			requestSchema: schemas.StockWatchRequestSchema, responseSchema: schemas.StockWatchResponseSchema},
+		// Watches live in memory, so renewing or cancelling one doesn't use the database
+		endpointDef[schemas.StockWatchRenewRequest, schemas.StockWatchRenewResponse]{name: "watch-renew", subject: "watch.renew", tx: noTx,
+			requestSchema: schemas.StockWatchRenewRequestSchema, responseSchema: schemas.StockWatchRenewResponseSchema, handle: app.stockWatchRenew}.endpoint(app),
+		endpointDef[schemas.StockWatchCancelRequest, schemas.StockWatchCancelResponse]{name: "watch-cancel", subject: "watch.cancel", tx: noTx,
+			requestSchema: schemas.StockWatchCancelRequestSchema, responseSchema: schemas.StockWatchCancelResponseSchema, handle: app.stockWatchCancel}.endpoint(app),
+		// The schemas are built into the binary
+		endpointDef[schemas.StockSchemaRequest, schemas.StockSchemaResponse]{name: "schema", subject: "schema", tx: noTx,
+			requestSchema: schemas.StockSchemaRequestSchema, responseSchema: schemas.StockSchemaResponseSchema, handle: app.stockSchema}.endpoint(app),
+	}
+}
+
+// eventSchemas are the IDs of the schemas of the events the App publishes
+var eventSchemas = []string{
+	schemas.LowStockEventSchema,
+	schemas.StockChangedEventSchema,
+}
+
+// schemaIDs returns the ID of every schema used by the endpoints and events
+func (app *App) schemaIDs() []string {
+	ids := append(slices.Clone(eventSchemas), schemas.HealthResponseSchema)
+	for _, v := range app.versions() {
+		for _, e := range v.endpoints {
+			ids = append(ids, e.requestSchema, e.responseSchema)
+		}
+	}
+	return ids
+}
+
 func (app *App) makeService() error {
 	config := micro.Config{
 		Name:        "StockService",
@@ -53,17 +215,18 @@ func (app *App) makeService() error {
 	if err != nil {
 		return err
 	}
-	// add a group to aggregate endpoints under common prefix
-	stock := svc.AddGroup("stock")
-	err = stock.AddEndpoint("add", micro.HandlerFunc(traceHandler(app.stockAddHandler)))
-	if err != nil {
-		return err
-	}
-	err = stock.AddEndpoint("remove", micro.HandlerFunc(traceHandler(app.stockRemoveHandler)))
-	if err != nil {
-		return err
+	for _, r := range app.routes() {
+		err = svc.AddEndpoint(r.name(), app.wrapHandler(r), micro.WithEndpointSubject(r.subject), micro.WithEndpointMetadata(r.metadata()))
+		if err != nil {
+			return err
+		}
 	}
-	err = stock.AddEndpoint("get", micro.HandlerFunc(traceHandler(app.stockGetHandler)))
+	// The health endpoint sits outside the stock group, and skips the tenant and authorization checks,
+	// so monitoring can call it without credentials
+	health := Chain(app.healthHandler, traceMiddleware, recoverMiddleware)
+	err = svc.AddEndpoint("health", micro.HandlerFunc(func(req micro.Request) {
+		health(context.Background(), req)
+	}), micro.WithEndpointSubject(HealthSubject))
 	if err != nil {
 		return err
 	}
@@ -71,14 +234,18 @@ func (app *App) makeService() error {
 	return nil
 }
 
-func traceHandler(handler func(ctx context.Context, req micro.Request)) micro.HandlerFunc {
-	return func(req micro.Request) {
-		ctx := context.Background()
-		// Start a new otel trace span
-		tracer := telemetry.GetTracer()
-		ctx, span := tracer.Start(ctx, req.Subject())
-		defer span.End()
-		slog.InfoContext(ctx, "API Request "+req.Subject())
-		handler(ctx, req)
+// rejectRequest replies with a caller error to a request that is turned away before it reaches its handler
+func rejectRequest(ctx context.Context, req micro.Request, err error, opts ...micro.RespondOpt) {
+	slog.InfoContext(ctx, "caller error", "error", err, "caller", identityFrom(ctx).String())
+	replyWithError(ctx, req, err, false, opts...)
+}
+
+// replyWithError replies to a request with an error response
+func replyWithError(ctx context.Context, req micro.Request, err error, isSystemError bool, opts ...micro.RespondOpt) {
+	resp := &schemas.ErrorResponse{}
+	resp.SetErrorAttributes(err, errorDetail(err, isSystemError))
+	opts = append(opts, withErrorCode(err, isSystemError))
+	if err := req.RespondJSON(resp, opts...); err != nil {
+		slog.ErrorContext(ctx, "RespondJSON returned error", "error", err)
 	}
 }
@@ -1,20 +1,66 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/davidoram/beaker/client"
+	"github.com/davidoram/beaker/internal/authz"
 	"github.com/davidoram/beaker/internal/db"
+	"github.com/davidoram/beaker/internal/ratelimit"
+	"github.com/davidoram/beaker/internal/sku"
+	"github.com/davidoram/beaker/internal/telemetry"
 	"github.com/davidoram/beaker/internal/utility"
 	"github.com/davidoram/beaker/schemas"
-	"github.com/nats-io/gnatsd/server"
-	natsserver "github.com/nats-io/nats-server/test"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTenantHeader   = "Beaker-Tenant"
+	testGatewayAccount = "GATEWAY"
+	testTenant         = "tenant-a"
+
+	// testPolicy lets the test tenants use everything, the reader tenant get stock, and the tea tenant
+	// add stock but only list tea
+	testPolicy = `{
+  "roles": {
+    "admin": {"endpoints": ["*"]},
+    "reader": {"endpoints": ["stock.get"]},
+    "coffee-writer": {"endpoints": ["stock.add"], "skus": ["coffee-*"]},
+    "writer": {"endpoints": ["stock.add"]},
+    "tea-lister": {"endpoints": ["stock.list"], "skus": ["tea-*"]}
+  },
+  "bindings": [
+    {"tenant": "tenant-a", "roles": ["admin"]},
+    {"tenant": "tenant-b", "roles": ["admin"]},
+    {"tenant": "tenant-limited", "roles": ["admin"]},
+    {"tenant": "tenant-replicas", "roles": ["admin"]},
+    {"tenant": "tenant-reader", "roles": ["reader", "coffee-writer"]},
+    {"tenant": "tenant-tea", "roles": ["writer", "tea-lister"]}
+  ]
+}`
 )
 
 func TestApp(t *testing.T) {
@@ -28,10 +74,21 @@ func TestApp(t *testing.T) {
 	pool := db.TestPostgresPool(t)
 	defer pool.Close()
 
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
 	compiler, err := utility.NewJSONSchemaCompiler(t.Context(), "../../schemas")
 	require.NoError(t, err)
 
-	app, err := StartNewApp(nc, pool, compiler)
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte(testPolicy), 0o600))
+	authorizer, err := authz.NewFileAuthorizer(policyFile)
+	require.NoError(t, err)
+
+	rateLimiter := ratelimit.NewLimiter(ratelimit.Config{
+		Endpoints: map[string]ratelimit.Limit{"stock.watch.cancel": {Rate: 0.1, Burst: 2}},
+	})
+
+	app, err := StartNewApp(nc, pool, compiler, Config{TenantHeader: testTenantHeader, GatewayAccount: testGatewayAccount, Authorizer: authorizer, RateLimiter: rateLimiter, ResponseValidationRate: 1, SKUPolicy: sku.DefaultPolicy()})
 	require.NoError(t, err)
 	defer app.Stop() // nolint:errcheck
 
@@ -60,12 +117,19 @@ func TestApp(t *testing.T) {
 
 	t.Run("malformed add request", func(t *testing.T) {
 
-		// sku doesn't conform to the schema http://github.com/davidoram/beaker/schemas/product-sku.json
-		uniqueSku := fmt.Sprintf("$$-%d", time.Now().UnixNano())
+		// sku matches the schema http://github.com/davidoram/beaker/schemas/product-sku-reference.json, but
+		// isn't an alias, or a SKU once it is in canonical form
+		uniqueSku := fmt.Sprintf(" $$-Sku-%d", time.Now().UnixNano())
 
 		resp := addStock(t, nc, uniqueSku, 25)
 		require.False(t, resp.OK)
-		require.Contains(t, *resp.Error, fmt.Sprintf("product-sku': '%s' does not match pattern", uniqueSku))
+		// The error names the canonical SKU that was looked up, as well as the one that was sent
+		require.Contains(t, *resp.Error, fmt.Sprintf("%s: %q (canonical %q) is not an alias", ErrInvalidSKU, uniqueSku, strings.ToLower(strings.TrimSpace(uniqueSku))))
+		require.NotNil(t, resp.ErrorDetail)
+		assert.Equal(t, schemas.ErrorCodeValidationFailed, resp.ErrorDetail.Code)
+		assert.Equal(t, schemas.ErrorTypeCaller, resp.ErrorDetail.Type)
+		// The request conformed to its schema, so no part of it is reported as violating it
+		assert.Empty(t, resp.ErrorDetail.Violations)
 	})
 
 	t.Run("remove stock", func(t *testing.T) {
@@ -89,6 +153,52 @@ func TestApp(t *testing.T) {
 
 		require.False(t, resp.OK)
 		assert.Equal(t, fmt.Sprintf("stock level cannot go below zero for %s", uniqueSku), *resp.Error)
+		require.NotNil(t, resp.ErrorDetail)
+		assert.Equal(t, schemas.ErrorCodeInsufficientStock, resp.ErrorDetail.Code)
+		assert.Equal(t, *resp.Error, resp.ErrorDetail.Message)
+	})
+
+	t.Run("request deadline", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		addStock(t, nc, uniqueSku, 10)
+
+		// Hold a lock on the inventory row, so removing stock waits until the request's deadline passes
+		tx, err := pool.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback(t.Context()) // nolint:errcheck
+		queries := db.New(tx)
+		require.NoError(t, queries.SetTenant(t.Context(), testTenant))
+		_, err = queries.AddInventory(t.Context(), db.AddInventoryParams{TenantID: testTenant, ProductSku: uniqueSku, StockLevel: 1})
+		require.NoError(t, err)
+
+		reqBytes, err := json.Marshal(schemas.StockRemoveRequest{ProductSKU: uniqueSku, Quantity: 1})
+		require.NoError(t, err)
+		msg := nats.NewMsg("stock.remove")
+		msg.Data = reqBytes
+		setGatewayHeaders(msg.Header, testTenant)
+		msg.Header.Set(RequestTimeoutHeader, "200ms")
+		start := time.Now()
+		reply, err := nc.RequestMsgWithContext(t.Context(), msg)
+		require.NoError(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+
+		resp := schemas.StockRemoveResponse{}
+		require.NoError(t, json.Unmarshal(reply.Data, &resp))
+		require.False(t, resp.OK)
+		require.NotNil(t, resp.ErrorDetail)
+		assert.Equal(t, schemas.ErrorCodeTimeout, resp.ErrorDetail.Code)
+		assert.Equal(t, "504", reply.Header.Get(micro.ErrorCodeHeader))
+
+		// The timed out request was rolled back
+		require.NoError(t, tx.Rollback(t.Context()))
+		assert.Equal(t, 10, *getStock(t, nc, uniqueSku).Quantity)
+
+		// A timeout the caller can't be held to is rejected
+		msg.Header.Set(RequestTimeoutHeader, "soon")
+		reply, err = nc.RequestMsgWithContext(t.Context(), msg)
+		require.NoError(t, err)
+		assert.Equal(t, "400", reply.Header.Get(micro.ErrorCodeHeader))
 	})
 
 	t.Run("remove stock publishes low stock event", func(t *testing.T) {
@@ -99,7 +209,7 @@ func TestApp(t *testing.T) {
 		wg.Add(1)
 
 		// Write a subscriber to listen for the low stock event
-		sub, err := nc.Subscribe(schemas.LowStockEvent{}.Subject(), func(msg *nats.Msg) {
+		sub, err := nc.Subscribe(schemas.LowStockEvent{TenantID: testTenant}.Subject(), func(msg *nats.Msg) {
 			event := schemas.LowStockEvent{}
 			err := json.Unmarshal(msg.Data, &event)
 			require.NoError(t, err)
@@ -118,14 +228,181 @@ func TestApp(t *testing.T) {
 		wg.Wait()
 	})
 
+	t.Run("add and remove stock publish stock changed events", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+
+		// Filter on the product SKU using the subject hierarchy
+		sub, err := nc.SubscribeSync(schemas.StockChangedEvent{TenantID: testTenant, ProductSKU: uniqueSku}.Subject())
+		require.NoError(t, err)
+		defer sub.Unsubscribe() // nolint:errcheck
+
+		addStock(t, nc, uniqueSku, 11)
+		removeStock(t, nc, uniqueSku, 4)
+		// A failed remove changes nothing, so must not publish an event
+		removeStock(t, nc, uniqueSku, 100)
+
+		event := nextStockChangedEvent(t, sub)
+		assert.Equal(t, schemas.StockOperationAdd, event.Operation)
+		assert.Equal(t, 11, event.Delta)
+		assert.Equal(t, 0, event.OldLevel)
+		assert.Equal(t, 11, event.NewLevel)
+		assert.NotEmpty(t, event.RequestID)
+
+		event = nextStockChangedEvent(t, sub)
+		assert.Equal(t, schemas.StockOperationRemove, event.Operation)
+		assert.Equal(t, -4, event.Delta)
+		assert.Equal(t, 11, event.OldLevel)
+		assert.Equal(t, 7, event.NewLevel)
+
+		_, err = sub.NextMsg(250 * time.Millisecond)
+		require.ErrorIs(t, err, nats.ErrTimeout)
+	})
+
+	t.Run("events are published in a CloudEvents envelope", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+
+		sub, err := nc.SubscribeSync(schemas.StockChangedEvent{TenantID: testTenant, ProductSKU: uniqueSku}.Subject())
+		require.NoError(t, err)
+		defer sub.Unsubscribe() // nolint:errcheck
+
+		addStock(t, nc, uniqueSku, 2)
+		addStock(t, nc, uniqueSku, 3)
+
+		first, err := sub.NextMsg(2 * time.Second)
+		require.NoError(t, err)
+		second, err := sub.NextMsg(2 * time.Second)
+		require.NoError(t, err)
+
+		assert.Equal(t, "1.0", first.Header.Get(CloudEventSpecVersionHeader))
+		assert.Equal(t, EventSource, first.Header.Get(CloudEventSourceHeader))
+		assert.Equal(t, schemas.StockChangedEventType, first.Header.Get(CloudEventTypeHeader))
+		assert.Equal(t, schemas.StockChangedEventSchema, first.Header.Get(CloudEventDataSchemaHeader))
+		assert.Equal(t, "application/json", first.Header.Get(ContentTypeHeader))
+		_, err = time.Parse(time.RFC3339Nano, first.Header.Get(CloudEventTimeHeader))
+		assert.NoError(t, err)
+
+		// Every event has a unique ID so consumers can de-duplicate them
+		assert.NotEmpty(t, first.Header.Get(CloudEventIDHeader))
+		assert.NotEqual(t, first.Header.Get(CloudEventIDHeader), second.Header.Get(CloudEventIDHeader))
+	})
+
+	t.Run("events continue the caller's trace", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+		sub, err := nc.SubscribeSync(schemas.StockChangedEvent{TenantID: testTenant, ProductSKU: uniqueSku}.Subject())
+		require.NoError(t, err)
+		defer sub.Unsubscribe() // nolint:errcheck
+
+		reqBytes, err := json.Marshal(schemas.StockAddRequest{ProductSKU: uniqueSku, Quantity: 1})
+		require.NoError(t, err)
+		msg := nats.NewMsg("stock.add")
+		msg.Data = reqBytes
+		setGatewayHeaders(msg.Header, testTenant)
+		msg.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		_, err = nc.RequestMsgWithContext(t.Context(), msg)
+		require.NoError(t, err)
+
+		event, err := sub.NextMsg(2 * time.Second)
+		require.NoError(t, err)
+		ctx := telemetry.ExtractContext(t.Context(), event.Header)
+		assert.Equal(t, traceID, trace.SpanContextFromContext(ctx).TraceID().String())
+	})
+
+	t.Run("canonical skus", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+
+		// The SKU is put into canonical form before it is used, and the response echoes the canonical SKU
+		resp := addStock(t, nc, " "+strings.ToUpper(uniqueSku)+" ", 4)
+		require.True(t, resp.OK)
+		assert.Equal(t, uniqueSku, *resp.ProductSKU)
+		getResp := getStock(t, nc, strings.ToUpper(uniqueSku))
+		require.True(t, getResp.OK)
+		assert.Equal(t, uniqueSku, *getResp.ProductSKU)
+		assert.Equal(t, 4, *getResp.Quantity)
+
+		// A SKU that still isn't in canonical form is rejected before it reaches the database
+		resp = addStock(t, nc, "café", 1)
+		require.False(t, resp.OK)
+		assert.Equal(t, schemas.ErrorCodeValidationFailed, resp.ErrorDetail.Code)
+		assert.Contains(t, *resp.Error, ErrInvalidSKU.Error())
+	})
+
+	t.Run("sku aliases", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		barcode := fmt.Sprintf("%d", time.Now().UnixNano())
+		addSkuAlias(t, pool, testTenant, barcode, uniqueSku, "barcode")
+
+		resp := addStock(t, nc, barcode, 6)
+		require.True(t, resp.OK)
+		assert.Equal(t, uniqueSku, *resp.ProductSKU)
+		removeResp := removeStock(t, nc, barcode, 1)
+		require.True(t, removeResp.OK)
+		assert.Equal(t, uniqueSku, *removeResp.ProductSKU)
+		assert.Equal(t, 5, *removeResp.Quantity)
+
+		inbox := nc.NewInbox()
+		watchResp := watchStock(t, nc, inbox, barcode)
+		require.True(t, watchResp.OK)
+		assert.Equal(t, []schemas.StockLevel{{ProductSKU: uniqueSku, Quantity: 5}}, watchResp.StockLevels)
+		cancelWatch(t, nc, *watchResp.WatchID)
+
+		// An alias is stored in canonical form, so it is found however the caller writes it. Trimming removes
+		// every kind of white space, not just spaces.
+		legacyCode := "LEGACY-" + barcode
+		addSkuAlias(t, pool, testTenant, "\t"+legacyCode+"\n", uniqueSku, "legacy")
+		for _, alias := range []string{legacyCode, strings.ToLower(legacyCode), " Legacy-" + barcode + " ", "\tlegacy-" + barcode} {
+			getResp := requestJSON[schemas.StockGetResponse](t, nc, "stock.get", schemas.StockGetRequest{ProductSKU: alias})
+			require.True(t, getResp.OK, alias)
+			assert.Equal(t, uniqueSku, *getResp.ProductSKU, alias)
+			assert.Equal(t, 5, *getResp.Quantity, alias)
+		}
+
+		// The alias is put into canonical form by the policy it is added with. One that isn't left in the same
+		// format as a SKU is rejected, eg: when the policy doesn't trim.
+		lowercase := sku.Policy{Lowercase: true}
+		require.NoError(t, tryAddSkuAlias(t, pool, lowercase, testTenant, "OTHER-"+barcode, uniqueSku, "legacy"))
+		getResp := requestJSON[schemas.StockGetResponse](t, nc, "stock.get", schemas.StockGetRequest{ProductSKU: "other-" + barcode})
+		require.True(t, getResp.OK)
+		assert.Equal(t, uniqueSku, *getResp.ProductSKU)
+		err := tryAddSkuAlias(t, pool, lowercase, testTenant, "\tOTHER-"+barcode, uniqueSku, "legacy")
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, "sku_aliases_alias_format", pgErr.ConstraintName)
+
+		// Aliases belong to a tenant
+		otherResp := requestJSONForTenant[schemas.StockGetResponse](t, nc, "tenant-b", "stock.get", schemas.StockGetRequest{ProductSKU: barcode})
+		require.True(t, otherResp.OK)
+		assert.Equal(t, barcode, *otherResp.ProductSKU)
+
+		// The caller's roles apply to the product an alias stands for, not the alias
+		readerTenant := "tenant-reader"
+		addSkuAlias(t, pool, readerTenant, "coffee-"+barcode, "tea-"+uniqueSku, "legacy")
+		addSkuAlias(t, pool, readerTenant, barcode, "coffee-"+uniqueSku, "gtin")
+		addResp := requestJSONForTenant[schemas.StockAddResponse](t, nc, readerTenant, "stock.add", schemas.StockAddRequest{ProductSKU: "coffee-" + barcode, Quantity: 1})
+		require.False(t, addResp.OK)
+		assert.Equal(t, schemas.ErrorCodeForbidden, addResp.ErrorDetail.Code)
+		addResp = requestJSONForTenant[schemas.StockAddResponse](t, nc, readerTenant, "stock.add", schemas.StockAddRequest{ProductSKU: barcode, Quantity: 1})
+		require.True(t, addResp.OK)
+		assert.Equal(t, "coffee-"+uniqueSku, *addResp.ProductSKU)
+	})
+
 	t.Run("malformed remove request", func(t *testing.T) {
 
-		// sku doesn't conform to the schema http://github.com/davidoram/beaker/schemas/product-sku.json
+		// sku matches the schema http://github.com/davidoram/beaker/schemas/product-sku-reference.json, but
+		// isn't an alias, or a SKU once it is in canonical form
 		uniqueSku := fmt.Sprintf("^%%-%d", time.Now().UnixNano())
 
 		resp := removeStock(t, nc, uniqueSku, 25)
 		require.False(t, resp.OK)
-		require.Contains(t, *resp.Error, fmt.Sprintf("product-sku': '%s' does not match pattern", uniqueSku))
+		require.Contains(t, *resp.Error, fmt.Sprintf("%s: %q (canonical %q) is not an alias", ErrInvalidSKU, uniqueSku, uniqueSku))
+		assert.Equal(t, schemas.ErrorCodeValidationFailed, resp.ErrorDetail.Code)
+		assert.Empty(t, resp.ErrorDetail.Violations)
 	})
 
 	t.Run("get stock balance", func(t *testing.T) {
@@ -152,16 +429,496 @@ func TestApp(t *testing.T) {
 		assert.Equal(t, 2, *resp.Quantity)
 	})
 
+	t.Run("list stock", func(t *testing.T) {
+
+		prefix := fmt.Sprintf("list-%d", time.Now().UnixNano())
+		for i, suffix := range []string{"-a", "-b", "-c"} {
+			addStock(t, nc, prefix+suffix, i+1)
+		}
+
+		resp := requestJSON[schemas.StockListResponse](t, nc, "stock.list", schemas.StockListRequest{After: utility.Ptr(prefix), Limit: utility.Ptr(2)})
+		require.True(t, resp.OK)
+		assert.Equal(t, []schemas.StockLevel{{ProductSKU: prefix + "-a", Quantity: 1}, {ProductSKU: prefix + "-b", Quantity: 2}}, resp.StockLevels)
+		require.NotNil(t, resp.NextAfter)
+		assert.Equal(t, prefix+"-b", *resp.NextAfter)
+
+		resp = requestJSON[schemas.StockListResponse](t, nc, "stock.list", schemas.StockListRequest{After: resp.NextAfter, Limit: utility.Ptr(2)})
+		require.True(t, resp.OK)
+		require.NotEmpty(t, resp.StockLevels)
+		assert.Equal(t, schemas.StockLevel{ProductSKU: prefix + "-c", Quantity: 3}, resp.StockLevels[0])
+
+		// A caller whose role is limited to some products only sees those products
+		teaTenant := "tenant-tea"
+		for _, sku := range []string{"coffee-" + prefix, "tea-" + prefix} {
+			addResp := requestJSONForTenant[schemas.StockAddResponse](t, nc, teaTenant, "stock.add", schemas.StockAddRequest{ProductSKU: sku, Quantity: 1})
+			require.True(t, addResp.OK)
+		}
+		teaResp := requestJSONForTenant[schemas.StockListResponse](t, nc, teaTenant, "stock.list", schemas.StockListRequest{Limit: utility.Ptr(1000)})
+		require.True(t, teaResp.OK)
+		assert.Contains(t, teaResp.StockLevels, schemas.StockLevel{ProductSKU: "tea-" + prefix, Quantity: 1})
+		assert.NotContains(t, teaResp.StockLevels, schemas.StockLevel{ProductSKU: "coffee-" + prefix, Quantity: 1})
+	})
+
+	t.Run("watch stock", func(t *testing.T) {
+
+		watchedSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		otherSku := watchedSku + "-other"
+		addStock(t, nc, watchedSku, 5)
+
+		// Subscribe to the inbox before starting the watch so no updates are missed
+		inbox := nc.NewInbox()
+		sub, err := nc.SubscribeSync(inbox)
+		require.NoError(t, err)
+		defer sub.Unsubscribe() // nolint:errcheck
+
+		resp := watchStock(t, nc, inbox, watchedSku, otherSku)
+		require.True(t, resp.OK)
+		require.NotNil(t, resp.WatchID)
+		require.Equal(t, []schemas.StockLevel{
+			{ProductSKU: watchedSku, Quantity: 5},
+			{ProductSKU: otherSku, Quantity: 0},
+		}, resp.StockLevels)
+
+		// Each change to a watched product is published to the inbox
+		addStock(t, nc, watchedSku, 3)
+		update := nextWatchUpdate(t, sub)
+		assert.Equal(t, *resp.WatchID, update.WatchID)
+		assert.Equal(t, watchedSku, update.ProductSKU)
+		assert.Equal(t, 8, update.Quantity)
+
+		removeStock(t, nc, watchedSku, 6)
+		update = nextWatchUpdate(t, sub)
+		assert.Equal(t, 2, update.Quantity)
+
+		renewResp := renewWatch(t, nc, *resp.WatchID)
+		require.True(t, renewResp.OK)
+		require.True(t, renewResp.LeaseExpiresAt.After(*resp.LeaseExpiresAt) || renewResp.LeaseExpiresAt.Equal(*resp.LeaseExpiresAt))
+
+		// Once cancelled no more updates are published, and the watch can't be renewed
+		cancelResp := cancelWatch(t, nc, *resp.WatchID)
+		require.True(t, cancelResp.OK)
+		addStock(t, nc, watchedSku, 1)
+		_, err = sub.NextMsg(250 * time.Millisecond)
+		require.ErrorIs(t, err, nats.ErrTimeout)
+
+		renewResp = renewWatch(t, nc, *resp.WatchID)
+		require.False(t, renewResp.OK)
+		assert.Equal(t, ErrWatchNotFound.Error(), *renewResp.Error)
+		assert.Equal(t, schemas.ErrorCodeNotFound, renewResp.ErrorDetail.Code)
+	})
+
+	t.Run("watch lease expires", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		inbox := nc.NewInbox()
+
+		req := schemas.StockWatchRequest{
+			ProductSKUs:  []string{uniqueSku},
+			Inbox:        inbox,
+			LeaseSeconds: utility.Ptr(1),
+		}
+		resp := requestJSON[schemas.StockWatchResponse](t, nc, "stock.watch", req)
+		require.True(t, resp.OK)
+
+		require.Eventually(t, func() bool {
+			return !renewWatch(t, nc, *resp.WatchID).OK
+		}, 5*time.Second, 250*time.Millisecond)
+	})
+
+	t.Run("watch inbox must be an inbox", func(t *testing.T) {
+
+		// A watch would otherwise publish to the event subjects with the service's credentials
+		req := schemas.StockWatchRequest{
+			ProductSKUs: []string{"watched-sku"},
+			Inbox:       schemas.StockChangedSubjectPrefix + testTenant + ".watched-sku",
+		}
+		resp := requestJSON[schemas.StockWatchResponse](t, nc, "stock.watch", req)
+		require.False(t, resp.OK)
+		assert.Equal(t, schemas.ErrorCodeValidationFailed, resp.ErrorDetail.Code)
+		assert.Contains(t, *resp.Error, ErrInvalidInbox.Error())
+	})
+
+	t.Run("watches across instances", func(t *testing.T) {
+
+		// A second instance of the service shares the NATS server, so requests are spread between the two
+		nc2, err := nats.Connect(server.Addr().String())
+		require.NoError(t, err)
+		defer nc2.Close()
+		app2, err := StartNewApp(nc2, pool, compiler, Config{TenantHeader: testTenantHeader, GatewayAccount: testGatewayAccount, Authorizer: authorizer, ResponseValidationRate: 1, SKUPolicy: sku.DefaultPolicy()})
+		require.NoError(t, err)
+		defer app2.Stop() // nolint:errcheck
+
+		// Start watches until each instance owns one
+		tenant := "tenant-replicas"
+		owned := map[string]string{}
+		for i := 0; i < 50 && len(owned) < 2; i++ {
+			resp := requestJSONForTenant[schemas.StockWatchResponse](t, nc, tenant, "stock.watch",
+				schemas.StockWatchRequest{ProductSKUs: []string{"watched-sku"}, Inbox: nc.NewInbox()})
+			require.True(t, resp.OK)
+			owner, _, _ := strings.Cut(*resp.WatchID, ".")
+			owned[owner] = *resp.WatchID
+		}
+		require.Contains(t, owned, app.watches.instance)
+		require.Contains(t, owned, app2.watches.instance)
+
+		// Whichever instance a renew or cancel reaches, the watch is found
+		for _, watchID := range owned {
+			for range 10 {
+				renewResp := requestJSONForTenant[schemas.StockWatchRenewResponse](t, nc, tenant, "stock.watch.renew", schemas.StockWatchRenewRequest{WatchID: watchID})
+				require.True(t, renewResp.OK, "renew %s", watchID)
+			}
+			cancelResp := requestJSONForTenant[schemas.StockWatchCancelResponse](t, nc, tenant, "stock.watch.cancel", schemas.StockWatchCancelRequest{WatchID: watchID})
+			require.True(t, cancelResp.OK, "cancel %s", watchID)
+			renewResp := requestJSONForTenant[schemas.StockWatchRenewResponse](t, nc, tenant, "stock.watch.renew", schemas.StockWatchRenewRequest{WatchID: watchID})
+			require.False(t, renewResp.OK)
+			assert.Equal(t, schemas.ErrorCodeNotFound, renewResp.ErrorDetail.Code)
+		}
+	})
+
+	t.Run("tenants are isolated", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		otherTenant := "tenant-b"
+
+		// Events for the other tenant are published on its own subjects
+		sub, err := nc.SubscribeSync(schemas.StockChangedSubjectPrefix + otherTenant + ".>")
+		require.NoError(t, err)
+		defer sub.Unsubscribe() // nolint:errcheck
+
+		addStock(t, nc, uniqueSku, 10)
+		otherResp := requestJSONForTenant[schemas.StockAddResponse](t, nc, otherTenant, "stock.add", schemas.StockAddRequest{ProductSKU: uniqueSku, Quantity: 3})
+		require.True(t, otherResp.OK)
+		assert.Equal(t, 3, *otherResp.Quantity)
+
+		resp := getStock(t, nc, uniqueSku)
+		require.True(t, resp.OK)
+		assert.Equal(t, 10, *resp.Quantity)
+
+		otherGet := requestJSONForTenant[schemas.StockGetResponse](t, nc, otherTenant, "stock.get", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.True(t, otherGet.OK)
+		assert.Equal(t, 3, *otherGet.Quantity)
+
+		event := nextStockChangedEvent(t, sub)
+		assert.Equal(t, 3, event.NewLevel)
+		_, err = sub.NextMsg(250 * time.Millisecond)
+		require.ErrorIs(t, err, nats.ErrTimeout)
+	})
+
+	t.Run("tenant header is only honoured from the gateway", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		otherTenant := "tenant-b"
+		otherResp := requestJSONForTenant[schemas.StockAddResponse](t, nc, otherTenant, "stock.add", schemas.StockAddRequest{ProductSKU: uniqueSku, Quantity: 3})
+		require.True(t, otherResp.OK)
+
+		// A request from the tenant's own account can't name another tenant
+		reqBytes, err := json.Marshal(schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.NoError(t, err)
+		msg := nats.NewMsg("stock.get")
+		msg.Data = reqBytes
+		msg.Header.Set(RequestInfoHeader, `{"acc": "`+testTenant+`"}`)
+		msg.Header.Set(testTenantHeader, otherTenant)
+		reply, err := nc.RequestMsgWithContext(t.Context(), msg)
+		require.NoError(t, err)
+		resp := schemas.StockGetResponse{}
+		require.NoError(t, json.Unmarshal(reply.Data, &resp))
+		require.True(t, resp.OK)
+		assert.Equal(t, 0, *resp.Quantity)
+	})
+
+	t.Run("row level security isolates tenants", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		addStock(t, nc, uniqueSku, 5)
+
+		// The service's role must be subject to row level security
+		var superuser, bypassRLS bool
+		require.NoError(t, pool.QueryRow(t.Context(), "select rolsuper, rolbypassrls from pg_roles where rolname = current_user").Scan(&superuser, &bypassRLS))
+		require.False(t, superuser)
+		require.False(t, bypassRLS)
+
+		// Even a query that names the other tenant only sees the rows of the transaction's tenant
+		tx, err := pool.Begin(t.Context())
+		require.NoError(t, err)
+		defer tx.Rollback(t.Context()) // nolint:errcheck
+		require.NoError(t, db.New(tx).SetTenant(t.Context(), "tenant-b"))
+		var count int
+		require.NoError(t, tx.QueryRow(t.Context(), "select count(*) from inventory where tenant_id = $1 and product_sku = $2", testTenant, uniqueSku).Scan(&count))
+		assert.Equal(t, 0, count)
+
+		_, err = tx.Exec(t.Context(), "update inventory set stock_level = 0 where tenant_id = $1", testTenant)
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit(t.Context()))
+		assert.Equal(t, 5, *getStock(t, nc, uniqueSku).Quantity)
+	})
+
+	t.Run("authorization policy", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		readerTenant := "tenant-reader"
+
+		getResp := requestJSONForTenant[schemas.StockGetResponse](t, nc, readerTenant, "stock.get", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.True(t, getResp.OK)
+
+		// Reader can't remove stock
+		removeResp := requestJSONForTenant[schemas.StockRemoveResponse](t, nc, readerTenant, "stock.remove", schemas.StockRemoveRequest{ProductSKU: uniqueSku, Quantity: 1})
+		require.False(t, removeResp.OK)
+		assert.Contains(t, *removeResp.Error, authz.ErrForbidden.Error())
+
+		// Reader can only add coffee products
+		addResp := requestJSONForTenant[schemas.StockAddResponse](t, nc, readerTenant, "stock.add", schemas.StockAddRequest{ProductSKU: uniqueSku, Quantity: 1})
+		require.False(t, addResp.OK)
+		assert.Contains(t, *addResp.Error, authz.ErrForbidden.Error())
+
+		addResp = requestJSONForTenant[schemas.StockAddResponse](t, nc, readerTenant, "stock.add", schemas.StockAddRequest{ProductSKU: "coffee-" + uniqueSku, Quantity: 1})
+		require.True(t, addResp.OK)
+	})
+
+	t.Run("rate limits", func(t *testing.T) {
+
+		limitedTenant := "tenant-limited"
+		reqBytes, err := json.Marshal(schemas.StockWatchCancelRequest{WatchID: uuid.NewString()})
+		require.NoError(t, err)
+
+		// The burst reaches the handler, which can't find the watch
+		for range 2 {
+			msg := request(t, nc, limitedTenant, "stock.watch.cancel", reqBytes)
+			resp := schemas.StockWatchCancelResponse{}
+			require.NoError(t, json.Unmarshal(msg.Data, &resp))
+			require.False(t, resp.OK)
+			assert.Equal(t, ErrWatchNotFound.Error(), *resp.Error)
+		}
+
+		// Then the caller is turned away, and told when to retry
+		msg := request(t, nc, limitedTenant, "stock.watch.cancel", reqBytes)
+		resp := schemas.StockWatchCancelResponse{}
+		require.NoError(t, json.Unmarshal(msg.Data, &resp))
+		require.False(t, resp.OK)
+		assert.Contains(t, *resp.Error, ratelimit.ErrRateLimited.Error())
+		assert.NotEmpty(t, msg.Header.Get(RetryAfterHeader))
+
+		// Other callers have their own allowance
+		cancelResp := requestJSON[schemas.StockWatchCancelResponse](t, nc, "stock.watch.cancel", schemas.StockWatchCancelRequest{WatchID: uuid.NewString()})
+		assert.Equal(t, ErrWatchNotFound.Error(), *cancelResp.Error)
+	})
+
+	t.Run("http gateway", func(t *testing.T) {
+
+		tokensFile := filepath.Join(t.TempDir(), "tokens.json")
+		tokens := fmt.Sprintf(`{"%x": {"account": "ACME", "tenant": "%s"}, "%x": {"account": "READER", "tenant": "tenant-reader"}}`,
+			sha256.Sum256([]byte("writer-token")), testTenant, sha256.Sum256([]byte("reader-token")))
+		require.NoError(t, os.WriteFile(tokensFile, []byte(tokens), 0o600))
+		bearerTokens, err := LoadBearerTokens(tokensFile)
+		require.NoError(t, err)
+
+		server := httptest.NewServer(app.HTTPHandler(bearerTokens))
+		defer server.Close()
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+
+		// Stock added over HTTP is visible over NATS
+		status, addResp := postJSON[schemas.StockAddResponse](t, server.URL+"/stock/add", "writer-token", schemas.StockAddRequest{ProductSKU: uniqueSku, Quantity: 5})
+		require.Equal(t, http.StatusOK, status)
+		require.True(t, addResp.OK)
+		assert.Equal(t, 5, *addResp.Quantity)
+		assert.Equal(t, 5, *getStock(t, nc, uniqueSku).Quantity)
+
+		// Caller errors
+		status, getResp := postJSON[schemas.StockGetResponse](t, server.URL+"/stock/get", "writer-token", map[string]string{"sku": uniqueSku})
+		require.Equal(t, http.StatusBadRequest, status)
+		require.False(t, getResp.OK)
+
+		status, _ = postJSON[schemas.StockGetResponse](t, server.URL+"/stock/get", "", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.Equal(t, http.StatusUnauthorized, status)
+
+		status, _ = postJSON[schemas.StockGetResponse](t, server.URL+"/stock/get", "unknown-token", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.Equal(t, http.StatusUnauthorized, status)
+
+		status, removeResp := postJSON[schemas.StockRemoveResponse](t, server.URL+"/stock/remove", "reader-token", schemas.StockRemoveRequest{ProductSKU: uniqueSku, Quantity: 1})
+		require.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, *removeResp.Error, authz.ErrForbidden.Error())
+
+		status, _ = postJSON[schemas.StockWatchCancelResponse](t, server.URL+"/stock/watch/cancel", "writer-token", schemas.StockWatchCancelRequest{WatchID: uuid.NewString()})
+		require.Equal(t, http.StatusNotFound, status)
+
+		resp, err := http.Get(server.URL + "/stock/get")
+		require.NoError(t, err)
+		defer resp.Body.Close() // nolint:errcheck
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	})
+
+	t.Run("request without a tenant", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+
+		resp := requestJSONForTenant[schemas.StockGetResponse](t, nc, "", "stock.get", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.False(t, resp.OK)
+		assert.Equal(t, ErrNoTenant.Error(), *resp.Error)
+	})
+
 	t.Run("malformed get request", func(t *testing.T) {
 
-		// sku doesn't conform to the schema http://github.com/davidoram/beaker/schemas/product-sku.json
+		// An empty sku doesn't conform to the schema http://github.com/davidoram/beaker/schemas/product-sku-reference.json
 		uniqueSku := ""
 
 		resp := getStock(t, nc, uniqueSku)
 		require.False(t, resp.OK)
-		require.Contains(t, *resp.Error, fmt.Sprintf("product-sku': '%s' does not match pattern", uniqueSku))
+		require.Contains(t, *resp.Error, "at '/product-sku': minLength: got 0, want 1")
+		assert.Equal(t, schemas.ErrorCodeValidationFailed, resp.ErrorDetail.Code)
+		assert.Equal(t, []string{"/product-sku"}, resp.ErrorDetail.Violations)
+	})
+
+	t.Run("versioned subjects", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+
+		// The versioned subjects serve the same stock as the legacy subjects
+		addResp := requestJSON[schemas.StockAddResponse](t, nc, "stock.v1.add", schemas.StockAddRequest{ProductSKU: uniqueSku, Quantity: 3})
+		require.True(t, addResp.OK)
+		getResp := requestJSON[schemas.StockGetResponse](t, nc, "stock.v1.get", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.True(t, getResp.OK)
+		assert.Equal(t, 3, *getResp.Quantity)
+		assert.Equal(t, 3, *getStock(t, nc, uniqueSku).Quantity)
+
+		// The authorization policy names endpoints without their version, so it applies to every version
+		removeResp := requestJSONForTenant[schemas.StockRemoveResponse](t, nc, "tenant-reader", "stock.v1.remove", schemas.StockRemoveRequest{ProductSKU: uniqueSku, Quantity: 1})
+		require.False(t, removeResp.OK)
+		assert.Equal(t, schemas.ErrorCodeForbidden, removeResp.ErrorDetail.Code)
+		getResp = requestJSONForTenant[schemas.StockGetResponse](t, nc, "tenant-reader", "stock.v1.get", schemas.StockGetRequest{ProductSKU: uniqueSku})
+		require.True(t, getResp.OK)
+
+		// There is no v2 until an endpoint needs a breaking change
+		_, err := nc.Request("stock.v2.get", []byte(`{}`), 250*time.Millisecond)
+		require.ErrorIs(t, err, nats.ErrNoResponders)
+	})
+
+	t.Run("describe endpoints", func(t *testing.T) {
+
+		// The service info carries each endpoint's schemas as metadata
+		msg, err := nc.Request("$SRV.INFO.StockService", nil, time.Second)
+		require.NoError(t, err)
+		info := micro.Info{}
+		require.NoError(t, json.Unmarshal(msg.Data, &info))
+		metadata := map[string]map[string]string{}
+		for _, e := range info.Endpoints {
+			metadata[e.Subject] = e.Metadata
+		}
+		assert.Equal(t, schemas.StockAddRequestSchema, metadata["stock.add"][RequestSchemaMetadata])
+		assert.Equal(t, schemas.StockAddResponseSchema, metadata["stock.add"][ResponseSchemaMetadata])
+		assert.Equal(t, APIVersion1, metadata["stock.v1.add"][APIVersionMetadata])
+
+		resp := requestJSON[schemas.StockSchemaResponse](t, nc, "stock.schema", schemas.StockSchemaRequest{Endpoint: utility.Ptr("stock.add")})
+		require.True(t, resp.OK)
+		require.Len(t, resp.Endpoints, 1)
+		assert.Equal(t, "stock.v1.add", resp.Endpoints[0].Subject)
+		assert.Equal(t, schemas.StockAddRequestSchema, resp.Endpoints[0].RequestSchema["$id"])
+		assert.Contains(t, resp.Endpoints[0].RequestSchema["$defs"], "product-sku.json")
+
+		resp = requestJSON[schemas.StockSchemaResponse](t, nc, "stock.schema", schemas.StockSchemaRequest{Endpoint: utility.Ptr("stock.missing")})
+		require.False(t, resp.OK)
+		assert.Equal(t, schemas.ErrorCodeNotFound, resp.ErrorDetail.Code)
+	})
+
+	t.Run("health checks", func(t *testing.T) {
+
+		// Over NATS, without a tenant
+		msg, err := nc.Request(HealthSubject, nil, time.Second)
+		require.NoError(t, err)
+		assert.Empty(t, msg.Header.Get(micro.ErrorCodeHeader))
+		health := schemas.HealthResponse{}
+		require.NoError(t, json.Unmarshal(msg.Data, &health))
+		assert.Equal(t, schemas.HealthStatusPass, health.Status)
+		var names []string
+		for _, check := range health.Checks {
+			names = append(names, check.Name)
+			assert.Equal(t, schemas.HealthStatusPass, check.Status, check.Name)
+		}
+		assert.ElementsMatch(t, []string{"shutdown", "postgres", "nats", "schemas", "migrations"}, names)
+
+		// Over HTTP
+		server := httptest.NewServer(app.HealthHandler())
+		defer server.Close()
+		for _, path := range []string{"/healthz", "/readyz"} {
+			resp, err := http.Get(server.URL + path)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		}
+	})
+
+	t.Run("readiness with a busy pool", func(t *testing.T) {
+
+		config := pool.Config()
+		config.MaxConns = 1
+		busyPool, err := pgxpool.NewWithConfig(t.Context(), config)
+		require.NoError(t, err)
+		defer busyPool.Close()
+		busyApp := &App{db: busyPool}
+
+		// Every connection being in use isn't a failure, as long as one is released soon
+		conn, err := busyPool.Acquire(t.Context())
+		require.NoError(t, err)
+		time.AfterFunc(100*time.Millisecond, conn.Release)
+		require.NoError(t, busyApp.checkPostgres(t.Context()))
+
+		// A connection that is never released is
+		conn, err = busyPool.Acquire(t.Context())
+		require.NoError(t, err)
+		defer conn.Release()
+		require.ErrorIs(t, busyApp.checkPostgres(t.Context()), ErrNoConnections)
+	})
+
+	// Must run last, it stops the app
+	t.Run("typed client", func(t *testing.T) {
+
+		uniqueSku := fmt.Sprintf("sku-%d", time.Now().UnixNano())
+		stock, err := client.New(nc, client.WithHeader(testTenantHeader, testTenant), client.WithHeader(RequestInfoHeader, testGatewayRequestInfo), client.WithValidation())
+		require.NoError(t, err)
+
+		lowStock := make(chan schemas.LowStockEvent, 1)
+		sub, err := stock.SubscribeLowStock(testTenant, func(e schemas.LowStockEvent) {
+			if e.ProductSKU == uniqueSku {
+				lowStock <- e
+			}
+		})
+		require.NoError(t, err)
+		defer sub.Unsubscribe() // nolint:errcheck
+
+		level, err := stock.Add(t.Context(), uniqueSku, LowStockThreshold+1)
+		require.NoError(t, err)
+		assert.Equal(t, schemas.StockLevel{ProductSKU: uniqueSku, Quantity: LowStockThreshold + 1}, level)
+
+		_, err = stock.Remove(t.Context(), uniqueSku, LowStockThreshold+2)
+		require.ErrorIs(t, err, client.ErrInsufficientStock)
+
+		level, err = stock.Remove(t.Context(), uniqueSku, 2)
+		require.NoError(t, err)
+		assert.Equal(t, LowStockThreshold-1, level.Quantity)
+		select {
+		case e := <-lowStock:
+			assert.Equal(t, testTenant, e.TenantID)
+			assert.Equal(t, LowStockThreshold-1, e.StockLevel)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no low stock event")
+		}
+
+		level, err = stock.Get(t.Context(), uniqueSku)
+		require.NoError(t, err)
+		assert.Equal(t, LowStockThreshold-1, level.Quantity)
 	})
 
+	t.Run("graceful shutdown", func(t *testing.T) {
+
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, app.Shutdown(ctx))
+
+		// The endpoints no longer answer, so callers move on to another instance
+		msg := nats.NewMsg("stock.get")
+		msg.Data = []byte(`{"product-sku": "coffee-cup"}`)
+		_, err := nc.RequestMsg(msg, time.Second)
+		require.ErrorIs(t, err, nats.ErrNoResponders)
+	})
 }
 
 func addStock(t *testing.T, nc *nats.Conn, uniqueSku string, quantity int) schemas.StockAddResponse {
@@ -173,8 +930,7 @@ func addStock(t *testing.T, nc *nats.Conn, uniqueSku string, quantity int) schem
 	reqBytes, err := json.Marshal(req)
 	require.NoError(t, err)
 
-	msg, err := nc.RequestWithContext(t.Context(), "stock.add", reqBytes)
-	require.NoError(t, err)
+	msg := request(t, nc, testTenant, "stock.add", reqBytes)
 
 	// Parse the response & check values
 	resp := schemas.StockAddResponse{}
@@ -193,8 +949,7 @@ func removeStock(t *testing.T, nc *nats.Conn, uniqueSku string, quantity int) sc
 	reqBytes, err := json.Marshal(req)
 	require.NoError(t, err)
 
-	msg, err := nc.RequestWithContext(t.Context(), "stock.remove", reqBytes)
-	require.NoError(t, err)
+	msg := request(t, nc, testTenant, "stock.remove", reqBytes)
 
 	// Parse the response & check values
 	resp := schemas.StockRemoveResponse{}
@@ -212,8 +967,7 @@ func getStock(t *testing.T, nc *nats.Conn, uniqueSku string) schemas.StockGetRes
 	reqBytes, err := json.Marshal(req)
 	require.NoError(t, err)
 
-	msg, err := nc.RequestWithContext(t.Context(), "stock.get", reqBytes)
-	require.NoError(t, err)
+	msg := request(t, nc, testTenant, "stock.get", reqBytes)
 
 	// Parse the response & check values
 	resp := schemas.StockGetResponse{}
@@ -223,6 +977,122 @@ func getStock(t *testing.T, nc *nats.Conn, uniqueSku string) schemas.StockGetRes
 	return resp
 }
 
+func watchStock(t *testing.T, nc *nats.Conn, inbox string, skus ...string) schemas.StockWatchResponse {
+	req := schemas.StockWatchRequest{
+		ProductSKUs: skus,
+		Inbox:       inbox,
+	}
+	return requestJSON[schemas.StockWatchResponse](t, nc, "stock.watch", req)
+}
+
+func renewWatch(t *testing.T, nc *nats.Conn, watchID string) schemas.StockWatchRenewResponse {
+	req := schemas.StockWatchRenewRequest{WatchID: watchID}
+	return requestJSON[schemas.StockWatchRenewResponse](t, nc, "stock.watch.renew", req)
+}
+
+func cancelWatch(t *testing.T, nc *nats.Conn, watchID string) schemas.StockWatchCancelResponse {
+	req := schemas.StockWatchCancelRequest{WatchID: watchID}
+	return requestJSON[schemas.StockWatchCancelResponse](t, nc, "stock.watch.cancel", req)
+}
+
+func nextStockChangedEvent(t *testing.T, sub *nats.Subscription) schemas.StockChangedEvent {
+	msg, err := sub.NextMsg(2 * time.Second)
+	require.NoError(t, err)
+	event := schemas.StockChangedEvent{}
+	require.NoError(t, json.Unmarshal(msg.Data, &event))
+	return event
+}
+
+func nextWatchUpdate(t *testing.T, sub *nats.Subscription) schemas.StockWatchUpdate {
+	msg, err := sub.NextMsg(2 * time.Second)
+	require.NoError(t, err)
+	update := schemas.StockWatchUpdate{}
+	require.NoError(t, json.Unmarshal(msg.Data, &update))
+	return update
+}
+
+// addSkuAlias makes alias stand for the product SKU in the tenant's inventory, in the canonical form of the
+// default policy that the test App uses
+func addSkuAlias(t *testing.T, pool *pgxpool.Pool, tenant, alias, productSKU, aliasType string) {
+	require.NoError(t, tryAddSkuAlias(t, pool, sku.DefaultPolicy(), tenant, alias, productSKU, aliasType))
+}
+
+// tryAddSkuAlias makes alias stand for the product SKU in the tenant's inventory, in the policy's canonical
+// form, and returns the error if the database rejects it
+func tryAddSkuAlias(t *testing.T, pool *pgxpool.Pool, policy sku.Policy, tenant, alias, productSKU, aliasType string) error {
+	tx, err := pool.Begin(t.Context())
+	require.NoError(t, err)
+	defer tx.Rollback(t.Context()) // nolint:errcheck
+	queries := db.New(tx)
+	require.NoError(t, queries.SetTenant(t.Context(), tenant))
+	if err := AddSkuAlias(t.Context(), queries, policy, db.AddSkuAliasParams{TenantID: tenant, Alias: alias, ProductSku: productSKU, AliasType: aliasType}); err != nil {
+		return err
+	}
+	return tx.Commit(t.Context())
+}
+
+// requestJSON sends req to subject on behalf of the test tenant and decodes the response into T
+func requestJSON[T any](t *testing.T, nc *nats.Conn, subject string, req any) T {
+	return requestJSONForTenant[T](t, nc, testTenant, subject, req)
+}
+
+// requestJSONForTenant sends req to subject on behalf of tenant and decodes the response into T
+func requestJSONForTenant[T any](t *testing.T, nc *nats.Conn, tenant, subject string, req any) T {
+	reqBytes, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	msg := request(t, nc, tenant, subject, reqBytes)
+
+	var resp T
+	err = json.Unmarshal(msg.Data, &resp)
+	require.NoError(t, err)
+
+	return resp
+}
+
+// postJSON posts req to url with a bearer token, and returns the HTTP status and the decoded response
+func postJSON[T any](t *testing.T, url, token string, req any) (int, T) {
+	reqBytes, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequestWithContext(t.Context(), http.MethodPost, url, bytes.NewReader(reqBytes))
+	require.NoError(t, err)
+	if token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint:errcheck
+
+	var decoded T
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	return resp.StatusCode, decoded
+}
+
+// request sends data to subject, passing the tenant in the same way as a trusted gateway.
+// Pass an empty tenant to send a request without one.
+func request(t *testing.T, nc *nats.Conn, tenant, subject string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	if tenant != "" {
+		setGatewayHeaders(msg.Header, tenant)
+	}
+	resp, err := nc.RequestMsgWithContext(t.Context(), msg)
+	require.NoError(t, err)
+	return resp
+}
+
+// testGatewayRequestInfo is the Nats-Request-Info header that the NATS server adds to requests made
+// through the gateway account's service import. The test clients are in the service's own account, so
+// they add it themselves.
+const testGatewayRequestInfo = `{"acc": "` + testGatewayAccount + `"}`
+
+// setGatewayHeaders passes the tenant in the same way as a trusted gateway
+func setGatewayHeaders(header nats.Header, tenant string) {
+	header.Set(RequestInfoHeader, testGatewayRequestInfo)
+	header.Set(testTenantHeader, tenant)
+}
+
 func runNatsServerOnPort(t *testing.T, port int) *server.Server {
 	t.Helper()
 	opts := natsserver.DefaultTestOptions
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/davidoram/beaker/internal/authz"
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/nats-io/nats.go/micro"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// authorizeMiddleware checks the caller is allowed to use the endpoint before passing the request on to the handler.
+// The products named in the request are checked by the handler with authorizeSKUs, once it has resolved their aliases.
+// Requests that are not allowed get an error wrapping authz.ErrForbidden. When no policy is configured every request
+// is allowed.
+func (app *App) authorizeMiddleware(next Handler) Handler {
+	if app.config.Authorizer == nil {
+		return next
+	}
+	return func(ctx context.Context, req micro.Request) {
+		tracer := telemetry.GetTracer()
+		authzCtx, span := tracer.Start(ctx, "authorize")
+
+		identity := identityFrom(ctx)
+		// A request without a usable tenant is rejected for that reason, rather than being forbidden
+		err := validateTenant(identity.Tenant)
+		if err == nil {
+			err = app.config.Authorizer.Authorize(principalOf(identity), endpointName(ctx, req), nil)
+		}
+		span.SetAttributes(attribute.Bool("beaker.authorized", err == nil))
+		span.End()
+		if err != nil {
+			rejectRequest(authzCtx, req, err)
+			return
+		}
+		next(ctx, req)
+	}
+}
+
+// authorizeSKUs checks the caller may use the endpoint with every one of the products. When no policy is
+// configured every product is allowed.
+func (app *App) authorizeSKUs(ctx context.Context, skus []string) error {
+	if app.config.Authorizer == nil {
+		return nil
+	}
+	r, ok := routeFrom(ctx)
+	if !ok {
+		return fmt.Errorf("%w: the endpoint is not known", authz.ErrForbidden)
+	}
+	return app.config.Authorizer.Authorize(principalOf(identityFrom(ctx)), r.endpointName(), skus)
+}
+
+// allowedSKU reports whether the caller may see the product, for endpoints that return products the
+// request doesn't name
+func (app *App) allowedSKU(ctx context.Context, sku string) bool {
+	return app.authorizeSKUs(ctx, []string{sku}) == nil
+}
+
+// principalOf returns the principal the policy is applied to for the caller
+func principalOf(identity callerIdentity) authz.Principal {
+	return authz.Principal{Account: identity.Account, User: identity.User, Tenant: identity.Tenant}
+}
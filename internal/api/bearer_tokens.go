@@ -0,0 +1,69 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrBadBearerTokens = errors.New("invalid bearer tokens file")
+
+// bearerTokenIdentity is the identity of a caller holding a bearer token
+type bearerTokenIdentity struct {
+	Account string `json:"account"`
+	User    string `json:"user,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+}
+
+// BearerTokens authenticates HTTP callers by the bearer token they send in the Authorization header.
+// Only the SHA-256 digest of each token is stored, so the tokens file doesn't hold any secrets.
+type BearerTokens struct {
+	identities map[[sha256.Size]byte]callerIdentity
+}
+
+// LoadBearerTokens reads a JSON file that maps the hex encoded SHA-256 digest of each token to the
+// identity of the caller holding it, eg:
+//
+//	{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08": {"account": "ACME", "user": "barista", "tenant": "acme"}}
+//
+// When the tenant is omitted it is the caller's account, in the same way as a NATS caller.
+func LoadBearerTokens(path string) (*BearerTokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]bearerTokenIdentity{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadBearerTokens, err)
+	}
+	tokens := &BearerTokens{identities: map[[sha256.Size]byte]callerIdentity{}}
+	for digest, entry := range entries {
+		decoded, err := hex.DecodeString(digest)
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("%w: %s is not a SHA-256 digest", ErrBadBearerTokens, digest)
+		}
+		if entry.Account == "" {
+			return nil, fmt.Errorf("%w: token %s has no account", ErrBadBearerTokens, digest)
+		}
+		identity := callerIdentity{Account: entry.Account, User: entry.User, Tenant: entry.Tenant}
+		if identity.Tenant == "" {
+			identity.Tenant = identity.Account
+		}
+		tokens.identities[[sha256.Size]byte(decoded)] = identity
+	}
+	return tokens, nil
+}
+
+// authenticate returns the identity of the caller holding the bearer token in an Authorization header
+func (b *BearerTokens) authenticate(authorization string) (callerIdentity, bool) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return callerIdentity{}, false
+	}
+	identity, ok := b.identities[sha256.Sum256([]byte(token))]
+	return identity, ok
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"time"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// Events are published using the binary content mode of the CloudEvents NATS protocol binding.
+// The event attributes travel in the message headers, and the message data is the bare event payload,
+// so consumers that only care about the payload can keep decoding it directly.
+// See https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/nats-protocol-binding.md
+const (
+	CloudEventsSpecVersion = "1.0"
+
+	// EventSource identifies this service as the producer of an event
+	EventSource = "/beaker/StockService"
+
+	CloudEventIDHeader          = "ce-id"
+	CloudEventSourceHeader      = "ce-source"
+	CloudEventSpecVersionHeader = "ce-specversion"
+	CloudEventTypeHeader        = "ce-type"
+	CloudEventTimeHeader        = "ce-time"
+	CloudEventDataSchemaHeader  = "ce-dataschema"
+	ContentTypeHeader           = "content-type"
+)
+
+// newCloudEventMsg wraps an already marshalled event in a CloudEvents envelope, ready to publish
+func newCloudEventMsg(event schemas.Event, data []byte) *nats.Msg {
+	msg := nats.NewMsg(event.Subject())
+	msg.Header.Set(CloudEventIDHeader, uuid.NewString())
+	msg.Header.Set(CloudEventSourceHeader, EventSource)
+	msg.Header.Set(CloudEventSpecVersionHeader, CloudEventsSpecVersion)
+	msg.Header.Set(CloudEventTypeHeader, event.Type())
+	msg.Header.Set(CloudEventTimeHeader, time.Now().UTC().Format(time.RFC3339Nano))
+	msg.Header.Set(CloudEventDataSchemaHeader, event.DataSchema())
+	msg.Header.Set(ContentTypeHeader, "application/json")
+	msg.Data = data
+	return msg
+}
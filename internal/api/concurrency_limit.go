@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go/micro"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// concurrencyMiddleware caps how many requests are handled at once, so a burst of requests is turned
+// away with an overloaded error rather than queueing for a database connection. It runs after the
+// deadline middleware, so a request never waits for a slot past its deadline.
+// When no concurrency limiter is configured requests are not capped.
+func (app *App) concurrencyMiddleware(next Handler) Handler {
+	if app.config.Concurrency == nil {
+		return next
+	}
+	return func(ctx context.Context, req micro.Request) {
+		release, wait, err := app.config.Concurrency.Acquire(ctx, endpointName(ctx, req))
+
+		outcome := "admitted"
+		if err != nil {
+			outcome = "overloaded"
+		}
+		app.metrics.concurrencyWait.Record(ctx, wait.Seconds(), metric.WithAttributes(
+			attribute.String("beaker.endpoint", endpointName(ctx, req)),
+			attribute.String("beaker.outcome", outcome),
+		))
+		if err != nil {
+			rejectRequest(ctx, req, err)
+			return
+		}
+		defer release()
+		next(ctx, req)
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestTimeoutHeader is the request header a caller can use to say how long they will wait for a
+// response, as a duration such as `500ms` or `2s`. It is usually the timeout the caller passed to
+// `nats.Request`, there is no point in working on a request once the caller has stopped waiting.
+const RequestTimeoutHeader = "Request-Timeout"
+
+var (
+	ErrBadRequestTimeout = errors.New("invalid request timeout")
+	ErrRequestTimeout    = errors.New("request timed out")
+)
+
+// deadlineMiddleware gives each request a deadline, taken from the caller's RequestTimeoutHeader and capped
+// by the MaxRequestTimeout. The deadline is held by the context, so it is passed through the request scope
+// to every database call, and a database call still running when the deadline passes is cancelled.
+func (app *App) deadlineMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		timeout, err := requestTimeout(req, app.config.MaxRequestTimeout)
+		if err != nil {
+			rejectRequest(ctx, req, err)
+			return
+		}
+		if timeout <= 0 {
+			next(ctx, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("beaker.deadline", deadline.Format(time.RFC3339Nano)))
+		next(ctx, req)
+	}
+}
+
+// requestTimeout returns how long the request may run for, zero means it has no deadline
+func requestTimeout(req micro.Request, maxTimeout time.Duration) (time.Duration, error) {
+	value := req.Headers().Get(RequestTimeoutHeader)
+	if value == "" {
+		return maxTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrBadRequestTimeout, value)
+	}
+	if maxTimeout > 0 && timeout > maxTimeout {
+		return maxTimeout, nil
+	}
+	return timeout, nil
+}
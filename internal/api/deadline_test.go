@@ -0,0 +1,43 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		max     time.Duration
+		timeout time.Duration
+		err     error
+	}{
+		{name: "no header", max: 30 * time.Second, timeout: 30 * time.Second},
+		{name: "no header or maximum", timeout: 0},
+		{name: "shorter than the maximum", header: "500ms", max: 30 * time.Second, timeout: 500 * time.Millisecond},
+		{name: "capped by the maximum", header: "1m", max: 30 * time.Second, timeout: 30 * time.Second},
+		{name: "no maximum", header: "1m", timeout: time.Minute},
+		{name: "not a duration", header: "soon", max: 30 * time.Second, err: ErrBadRequestTimeout},
+		{name: "not positive", header: "0s", max: 30 * time.Second, err: ErrBadRequestTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &httpRequest{headers: micro.Headers{}}
+			if tt.header != "" {
+				req.headers[RequestTimeoutHeader] = []string{tt.header}
+			}
+			timeout, err := requestTimeout(req, tt.max)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.timeout, timeout)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/jackc/pgx/v5"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// txMode says whether an endpoint uses the database, and whether it may change it
+type txMode int
+
+const (
+	// noTx endpoints don't use the database, so no connection is acquired
+	noTx txMode = iota
+	// readOnlyTx endpoints read the database in a read only transaction
+	readOnlyTx
+	// readWriteTx endpoints change the database, their transaction is committed when the request succeeds
+	readWriteTx
+)
+
+// endpointDef defines an endpoint by its request and response types, and the one function that does its
+// work. Every endpoint defined this way handles a request in the same steps: the request is validated
+// against its schema and decoded, handle is called, the transaction is committed or rolled back, and the
+// response is sent. A pointer to Resp must implement schemas.APIResponse.
+type endpointDef[Req any, Resp any] struct {
+	// name identifies the endpoint in the service info and stats
+	name string
+	// subject is relative to the stock group and version
+	subject        string
+	requestSchema  string
+	responseSchema string
+	tx             txMode
+	// middleware runs after the App's middleware, just before the handler
+	middleware []Middleware
+	// handle does the endpoint's work, and returns the successful response. It is only called with a valid
+	// request. It reports failures by adding an error to rs, and may return nil when it does.
+	handle func(ctx context.Context, rs *requestScope, req Req) *Resp
+}
+
+// endpoint returns the endpoint, ready to be served on its routes. It panics if the response type doesn't
+// implement schemas.APIResponse, which is found when the App starts, as endpoints are listed to compile
+// their schemas.
+func (d endpointDef[Req, Resp]) endpoint(app *App) endpoint {
+	if _, ok := any(new(Resp)).(schemas.APIResponse); !ok {
+		panic(fmt.Sprintf("endpoint %s: *%T does not implement schemas.APIResponse", d.name, *new(Resp)))
+	}
+	return endpoint{
+		name:           d.name,
+		subject:        d.subject,
+		handler:        d.handler(app),
+		middleware:     d.middleware,
+		requestSchema:  d.requestSchema,
+		responseSchema: d.responseSchema,
+	}
+}
+
+// handler returns the Handler that takes a request through the endpoint's steps
+func (d endpointDef[Req, Resp]) handler(app *App) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		rs := d.requestScope(ctx, app, req)
+		defer rs.Close(ctx)
+		rs.ValidateJSON(ctx, app.schemas, req.Data(), d.requestSchema)
+		decoded := DecodeRequest[Req](ctx, rs)
+		var resp *Resp
+		if !rs.HasError() {
+			resp = d.call(ctx, rs, decoded)
+		}
+		if resp == nil {
+			resp = new(Resp)
+		}
+		rs.CommitOrRollback(ctx)
+		rs.RespondJSON(ctx, req, app.responses, any(resp).(schemas.APIResponse))
+	}
+}
+
+// requestScope starts the request scope, with a database connection and transaction to suit the endpoint
+func (d endpointDef[Req, Resp]) requestScope(ctx context.Context, app *App, req micro.Request) *requestScope {
+	switch d.tx {
+	case readOnlyTx:
+		return newRequestScope(ctx, req, app.nc, app.db, pgx.ReadOnly)
+	case readWriteTx:
+		return newRequestScope(ctx, req, app.nc, app.db, pgx.ReadWrite)
+	}
+	return newRequestScope(ctx, req, app.nc, nil, pgx.ReadWrite)
+}
+
+// call runs the endpoint's handle function in its own span
+func (d endpointDef[Req, Resp]) call(ctx context.Context, rs *requestScope, req Req) *Resp {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "handle "+d.name)
+	defer span.End()
+	return d.handle(ctx, rs, req)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointDef(t *testing.T) {
+	compiler, err := utility.NewJSONSchemaCompiler(t.Context(), "../../schemas")
+	require.NoError(t, err)
+	registry, err := utility.NewSchemaRegistry(compiler, schemas.StockWatchCancelRequestSchema, schemas.StockWatchCancelResponseSchema)
+	require.NoError(t, err)
+	app := &App{schemas: registry}
+
+	var calls int
+	def := endpointDef[schemas.StockWatchCancelRequest, schemas.StockWatchCancelResponse]{
+		name: "watch-cancel", subject: "watch.cancel", tx: noTx,
+		requestSchema: schemas.StockWatchCancelRequestSchema, responseSchema: schemas.StockWatchCancelResponseSchema,
+		handle: func(ctx context.Context, rs *requestScope, req schemas.StockWatchCancelRequest) *schemas.StockWatchCancelResponse {
+			calls++
+			if req.WatchID == "missing" {
+				rs.AddCallerError(ctx, ErrWatchNotFound)
+				return nil
+			}
+			return &schemas.StockWatchCancelResponse{OK: true, WatchID: utility.Ptr(req.WatchID)}
+		},
+	}
+	e := def.endpoint(app)
+	assert.Equal(t, "watch-cancel", e.name)
+	assert.Equal(t, schemas.StockWatchCancelRequestSchema, e.requestSchema)
+	ctx := withIdentity(t.Context(), callerIdentity{Account: "ACME", Tenant: "acme"})
+
+	call := func(data string) (int, schemas.StockWatchCancelResponse) {
+		req, w := newTestRequest("stock.watch.cancel")
+		req.data = []byte(data)
+		e.handler(ctx, req)
+		resp := schemas.StockWatchCancelResponse{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	status, resp := call(`{"watch-id": "w-1"}`)
+	assert.Equal(t, http.StatusOK, status)
+	require.True(t, resp.OK)
+	assert.Equal(t, "w-1", *resp.WatchID)
+
+	// The handle function reports a failure
+	status, resp = call(`{"watch-id": "missing"}`)
+	assert.Equal(t, http.StatusNotFound, status)
+	require.False(t, resp.OK)
+	assert.Equal(t, schemas.ErrorCodeNotFound, resp.ErrorDetail.Code)
+	assert.Nil(t, resp.WatchID)
+
+	// An invalid request never reaches the handle function
+	status, resp = call(`{"watch": "w-1"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, schemas.ErrorCodeValidationFailed, resp.ErrorDetail.Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestEndpointDefResponseType(t *testing.T) {
+	def := endpointDef[schemas.StockGetRequest, schemas.StockLevel]{name: "bad"}
+	assert.Panics(t, func() { def.endpoint(&App{}) })
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/davidoram/beaker/internal/authz"
+	"github.com/davidoram/beaker/internal/concurrency"
+	"github.com/davidoram/beaker/internal/ratelimit"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+)
+
+// errorStatuses maps the error codes that don't mean a bad request, to their HTTP status code
+var errorStatuses = map[schemas.ErrorCode]int{
+	schemas.ErrorCodeInternal:     http.StatusInternalServerError,
+	schemas.ErrorCodeUnauthorized: http.StatusUnauthorized,
+	schemas.ErrorCodeForbidden:    http.StatusForbidden,
+	schemas.ErrorCodeNotFound:     http.StatusNotFound,
+	schemas.ErrorCodeRateLimited:  http.StatusTooManyRequests,
+	schemas.ErrorCodeTimeout:      http.StatusGatewayTimeout,
+	schemas.ErrorCodeOverloaded:   http.StatusServiceUnavailable,
+	schemas.ErrorCodeUnavailable:  http.StatusServiceUnavailable,
+}
+
+// errorCodeOf classifies an error with a stable code, that callers can rely on where they can't rely on
+// the error message
+func errorCodeOf(err error, isSystemError bool) schemas.ErrorCode {
+	var validationErr *jsonschema.ValidationError
+	switch {
+	case errors.Is(err, ErrRequestTimeout):
+		return schemas.ErrorCodeTimeout
+	case errors.Is(err, ErrShuttingDown):
+		return schemas.ErrorCodeUnavailable
+	case isSystemError:
+		return schemas.ErrorCodeInternal
+	case errors.Is(err, ErrNoTenant), errors.Is(err, ErrUnauthorized):
+		return schemas.ErrorCodeUnauthorized
+	case errors.Is(err, authz.ErrForbidden):
+		return schemas.ErrorCodeForbidden
+	case errors.Is(err, ErrWatchNotFound), errors.Is(err, ErrUnknownEndpoint):
+		return schemas.ErrorCodeNotFound
+	case errors.Is(err, ratelimit.ErrRateLimited):
+		return schemas.ErrorCodeRateLimited
+	case errors.Is(err, concurrency.ErrOverloaded):
+		return schemas.ErrorCodeOverloaded
+	case errors.Is(err, ErrInsufficientStock):
+		return schemas.ErrorCodeInsufficientStock
+	case errors.Is(err, ErrBusinessRule):
+		return schemas.ErrorCodeConflict
+	case errors.As(err, &validationErr), errors.Is(err, ErrInvalidSKU), errors.Is(err, ErrInvalidInbox):
+		return schemas.ErrorCodeValidationFailed
+	}
+	return schemas.ErrorCodeInvalidRequest
+}
+
+// errorCode classifies an error using an HTTP status code, so callers can tell their own mistakes
+// from problems inside our system, and know whether a retry might succeed
+func errorCode(err error, isSystemError bool) int {
+	if status, ok := errorStatuses[errorCodeOf(err, isSystemError)]; ok {
+		return status
+	}
+	return http.StatusBadRequest
+}
+
+// withErrorCode adds the error code to a failed response, using the header that NATS micro services
+// use to report errors
+func withErrorCode(err error, isSystemError bool) micro.RespondOpt {
+	return micro.WithHeaders(micro.Headers{micro.ErrorCodeHeader: {strconv.Itoa(errorCode(err, isSystemError))}})
+}
+
+// errorDetail describes an error in the machine readable form sent to callers
+func errorDetail(err error, isSystemError bool) *schemas.ErrorDetail {
+	detail := &schemas.ErrorDetail{
+		Code:       errorCodeOf(err, isSystemError),
+		Message:    err.Error(),
+		Type:       schemas.ErrorTypeCaller,
+		Violations: schemaViolations(err),
+	}
+	if isSystemError {
+		detail.Type = schemas.ErrorTypeSystem
+	}
+	return detail
+}
+
+// schemaViolations lists the locations in a JSON value that broke its schema, as JSON pointers,
+// eg: `/product-sku`. A missing or unexpected property is reported at the location of the property.
+func schemaViolations(err error) []string {
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil
+	}
+	var violations []string
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		var properties []string
+		switch k := e.ErrorKind.(type) {
+		case *kind.Required:
+			properties = k.Missing
+		case *kind.AdditionalProperties:
+			properties = k.Properties
+		}
+		if len(properties) == 0 {
+			violations = append(violations, jsonPointer(e.InstanceLocation))
+		}
+		for _, property := range properties {
+			violations = append(violations, jsonPointer(append(slices.Clone(e.InstanceLocation), property)))
+		}
+	}
+	walk(validationErr)
+	slices.Sort(violations)
+	return slices.Compact(violations)
+}
+
+// jsonPointer formats a location in a JSON value as a JSON pointer, see RFC 6901
+func jsonPointer(location []string) string {
+	var b strings.Builder
+	for _, token := range location {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/davidoram/beaker/internal/authz"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorDetail(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		isSystem bool
+		code     schemas.ErrorCode
+		status   int
+	}{
+		{"system error", fmt.Errorf("database error: connection reset"), true, schemas.ErrorCodeInternal, http.StatusInternalServerError},
+		{"insufficient stock", fmt.Errorf("%w for sku-1", ErrInsufficientStock), false, schemas.ErrorCodeInsufficientStock, http.StatusBadRequest},
+		{"business rule", fmt.Errorf("%w: too many", ErrBusinessRule), false, schemas.ErrorCodeConflict, http.StatusBadRequest},
+		{"watch not found", ErrWatchNotFound, false, schemas.ErrorCodeNotFound, http.StatusNotFound},
+		{"forbidden", fmt.Errorf("%w: stock.add", authz.ErrForbidden), false, schemas.ErrorCodeForbidden, http.StatusForbidden},
+		{"no tenant", ErrNoTenant, false, schemas.ErrorCodeUnauthorized, http.StatusUnauthorized},
+		{"other caller error", fmt.Errorf("JSON data is empty"), false, schemas.ErrorCodeInvalidRequest, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail := errorDetail(tt.err, tt.isSystem)
+			assert.Equal(t, tt.code, detail.Code)
+			assert.Equal(t, tt.err.Error(), detail.Message)
+			assert.Equal(t, tt.status, errorCode(tt.err, tt.isSystem))
+			if tt.isSystem {
+				assert.Equal(t, schemas.ErrorTypeSystem, detail.Type)
+			} else {
+				assert.Equal(t, schemas.ErrorTypeCaller, detail.Type)
+			}
+		})
+	}
+}
+
+func TestSchemaViolations(t *testing.T) {
+	compiler, err := utility.NewJSONSchemaCompiler(t.Context(), "../../schemas")
+	require.NoError(t, err)
+	registry, err := utility.NewSchemaRegistry(compiler, schemas.StockAddRequestSchema)
+	require.NoError(t, err)
+
+	err = validateJSON(registry, schemas.StockAddRequestSchema, []byte(`{"product-sku": "", "colour": "red"}`))
+	require.Error(t, err)
+
+	detail := errorDetail(err, false)
+	assert.Equal(t, schemas.ErrorCodeValidationFailed, detail.Code)
+	assert.Equal(t, []string{"/colour", "/product-sku", "/quantity"}, detail.Violations)
+
+	assert.Nil(t, schemaViolations(ErrWatchNotFound))
+	assert.Equal(t, "/a~1b/c~0d", jsonPointer([]string{"a/b", "c~d"}))
+}
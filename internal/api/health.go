@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	migrations "github.com/davidoram/beaker/db-migrations"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/jackc/pgx/v5"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// HealthSubject is the NATS subject that reports whether the service is ready to handle requests
+const HealthSubject = "beaker.health"
+
+// healthCheckTimeout bounds how long the readiness checks may take, so a stuck dependency is reported
+// as failing rather than leaving the caller waiting
+const healthCheckTimeout = 2 * time.Second
+
+// postgresAcquireTimeout is how long the postgres check waits for a pool connection, it leaves time for
+// the ping within the healthCheckTimeout
+const postgresAcquireTimeout = time.Second
+
+var (
+	ErrNoConnections    = errors.New("no database connections available")
+	ErrNATSDisconnected = errors.New("NATS is not connected")
+	ErrSchemasNotLoaded = errors.New("JSON schemas are not loaded")
+	ErrMigrationsBehind = errors.New("database has not been migrated")
+)
+
+// healthCheck checks that one of the things the service depends on is working
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// readinessChecks returns the checks that must pass before the service is ready to handle requests
+func (app *App) readinessChecks() []healthCheck {
+	return []healthCheck{
+		{name: "shutdown", check: app.checkNotDraining},
+		{name: "postgres", check: app.checkPostgres},
+		{name: "nats", check: app.checkNATS},
+		{name: "schemas", check: app.checkSchemas},
+		{name: "migrations", check: app.checkMigrations},
+	}
+}
+
+// Readiness runs the readiness checks at the same time, and reports the outcome and latency of each
+func (app *App) Readiness(ctx context.Context) *schemas.HealthResponse {
+	return runHealthChecks(ctx, app.readinessChecks())
+}
+
+func runHealthChecks(ctx context.Context, checks []healthCheck) *schemas.HealthResponse {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	resp := &schemas.HealthResponse{Status: schemas.HealthStatusPass, Checks: make([]schemas.HealthCheck, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Go(func() {
+			start := time.Now()
+			err := c.check(ctx)
+			result := schemas.HealthCheck{
+				Name:      c.name,
+				Status:    schemas.HealthStatusPass,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = schemas.HealthStatusFail
+				result.Error = utility.Ptr(err.Error())
+			}
+			resp.Checks[i] = result
+		})
+	}
+	wg.Wait()
+	for _, c := range resp.Checks {
+		if c.Status != schemas.HealthStatusPass {
+			resp.Status = schemas.HealthStatusFail
+		}
+	}
+	return resp
+}
+
+// checkNotDraining fails once shutdown has started, so load balancers stop sending requests
+// while the requests in flight finish
+func (app *App) checkNotDraining(ctx context.Context) error {
+	if app.requests.isDraining() {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// checkPostgres acquires a connection, then pings the database with it. A busy pool isn't a failure,
+// every connection is in use whenever the service is working hard, so the check only fails when no
+// connection is released within postgresAcquireTimeout.
+func (app *App) checkPostgres(ctx context.Context) error {
+	acquireCtx, cancel := context.WithTimeout(ctx, postgresAcquireTimeout)
+	defer cancel()
+	conn, err := app.db.Acquire(acquireCtx)
+	if err != nil {
+		if acquireCtx.Err() != nil && ctx.Err() == nil {
+			stat := app.db.Stat()
+			return fmt.Errorf("%w: none released within %s, %d of %d in use", ErrNoConnections, postgresAcquireTimeout, stat.AcquiredConns(), stat.MaxConns())
+		}
+		return err
+	}
+	defer conn.Release()
+	return conn.Ping(ctx)
+}
+
+// checkNATS checks the NATS connection is up, rather than reconnecting
+func (app *App) checkNATS(ctx context.Context) error {
+	if status := app.nc.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("%w: %s", ErrNATSDisconnected, status)
+	}
+	return nil
+}
+
+// checkSchemas checks that every schema the endpoints and events use was compiled
+func (app *App) checkSchemas(ctx context.Context) error {
+	if app.schemas == nil {
+		return ErrSchemasNotLoaded
+	}
+	for _, id := range app.schemaIDs() {
+		if _, err := app.schemas.Get(id); err != nil {
+			return fmt.Errorf("%w: %w", ErrSchemasNotLoaded, err)
+		}
+	}
+	return nil
+}
+
+// checkMigrations checks the database has been migrated at least as far as the newest migration this
+// build knows about. A database that is further ahead passes, so the previous build keeps running while
+// a new build is rolled out, migrations must leave the database usable by the previous build.
+func (app *App) checkMigrations(ctx context.Context) error {
+	var current string
+	err := app.db.QueryRow(ctx, "SELECT id FROM migrations ORDER BY id DESC LIMIT 1").Scan(&current)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if expected := migrations.Latest(); current < expected {
+		return fmt.Errorf("%w: at %q, expected %q", ErrMigrationsBehind, current, expected)
+	}
+	return nil
+}
+
+// healthHandler replies with the readiness of the service. A service that isn't ready replies with
+// an error code, so it reads as a failure to callers that only look at the headers.
+func (app *App) healthHandler(ctx context.Context, req micro.Request) {
+	resp := app.Readiness(ctx)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		slog.ErrorContext(ctx, "system error", "error", err)
+		replyWithError(ctx, req, err, true)
+		return
+	}
+	app.responses.check(ctx, req.Subject(), resp.Schema(), data)
+	var opts []micro.RespondOpt
+	if resp.Status != schemas.HealthStatusPass {
+		opts = append(opts, micro.WithHeaders(micro.Headers{micro.ErrorCodeHeader: {strconv.Itoa(http.StatusServiceUnavailable)}}))
+	}
+	if err := req.Respond(data, opts...); err != nil {
+		slog.ErrorContext(ctx, "Respond returned error", "error", err)
+	}
+}
+
+// HealthHandler serves the health checks over HTTP, for orchestrators such as Kubernetes. `GET /healthz`
+// reports the process is alive, and `GET /readyz` runs the readiness checks, replying with
+// 503 Service Unavailable when the service isn't ready. Neither needs a bearer token, so serve them on a
+// separate listener to the API.
+func (app *App) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, &schemas.HealthResponse{Status: schemas.HealthStatusPass, Checks: []schemas.HealthCheck{}})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, app.Readiness(r.Context()))
+	})
+	return mux
+}
+
+func writeHealth(w http.ResponseWriter, resp *schemas.HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != schemas.HealthStatusPass {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
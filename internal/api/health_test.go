@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunHealthChecks(t *testing.T) {
+	pass := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("broken") }
+
+	resp := runHealthChecks(t.Context(), []healthCheck{{name: "a", check: pass}, {name: "b", check: pass}})
+	assert.Equal(t, schemas.HealthStatusPass, resp.Status)
+	require.Len(t, resp.Checks, 2)
+	assert.Equal(t, "a", resp.Checks[0].Name)
+	assert.Nil(t, resp.Checks[0].Error)
+
+	resp = runHealthChecks(t.Context(), []healthCheck{{name: "a", check: pass}, {name: "b", check: fail}})
+	assert.Equal(t, schemas.HealthStatusFail, resp.Status)
+	assert.Equal(t, schemas.HealthStatusPass, resp.Checks[0].Status)
+	assert.Equal(t, schemas.HealthStatusFail, resp.Checks[1].Status)
+	assert.Equal(t, "broken", *resp.Checks[1].Error)
+
+	// A check that hangs is cut off by the timeout
+	hang := func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }
+	resp = runHealthChecks(t.Context(), []healthCheck{{name: "hang", check: hang}})
+	assert.Equal(t, schemas.HealthStatusFail, resp.Status)
+	assert.GreaterOrEqual(t, resp.Checks[0].LatencyMs, float64(healthCheckTimeout.Milliseconds()))
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	app := &App{requests: newRequestTracker()}
+	require.NoError(t, app.checkNotDraining(t.Context()))
+	app.requests.drain()
+	require.ErrorIs(t, app.checkNotDraining(t.Context()), ErrShuttingDown)
+}
+
+func TestCheckSchemas(t *testing.T) {
+	app := &App{}
+	require.ErrorIs(t, app.checkSchemas(t.Context()), ErrSchemasNotLoaded)
+}
+
+func TestHealthHandler(t *testing.T) {
+	app := &App{requests: newRequestTracker()}
+	app.requests.drain()
+	server := httptest.NewServer(app.HealthHandler())
+	defer server.Close()
+
+	// The process is alive while it shuts down
+	resp, err := http.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	defer resp.Body.Close() // nolint:errcheck
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	health := schemas.HealthResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, schemas.HealthStatusPass, health.Status)
+}
+
+func TestWriteHealth(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeHealth(w, &schemas.HealthResponse{
+		Status: schemas.HealthStatusFail,
+		Checks: []schemas.HealthCheck{{Name: "shutdown", Status: schemas.HealthStatusFail}},
+	})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status": "fail", "checks": [{"name": "shutdown", "status": "fail", "latency-ms": 0}]}`, w.Body.String())
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// maxHTTPRequestBytes limits the size of a request body sent over HTTP
+const maxHTTPRequestBytes = 1 << 20
+
+var (
+	ErrUnauthorized     = errors.New("unauthorized: a valid bearer token is required")
+	ErrAlreadyResponded = errors.New("request has already been responded to")
+)
+
+// HTTPHandler serves the stock endpoints over HTTP, eg: `POST /stock/add` calls the same handler as
+// the `stock.add` NATS endpoint. Callers authenticate with a bearer token. Failed requests get an HTTP
+// status code that tells caller errors apart from system errors.
+func (app *App) HTTPHandler(tokens *BearerTokens) http.Handler {
+	mux := http.NewServeMux()
+	for _, r := range app.routes() {
+		subject := r.subject
+		handler := app.routeHandler(r)
+		mux.HandleFunc("POST /"+strings.ReplaceAll(subject, ".", "/"), func(w http.ResponseWriter, r *http.Request) {
+			serveHTTP(w, r, tokens, subject, handler)
+		})
+	}
+	return mux
+}
+
+// serveHTTP authenticates an HTTP request, and passes it to the handler as a micro.Request. The handler's
+// context is the HTTP request's, so the work stops when the caller goes away or the server shuts down.
+func serveHTTP(w http.ResponseWriter, r *http.Request, tokens *BearerTokens, subject string, handler Handler) {
+	identity, ok := tokens.authenticate(r.Header.Get("Authorization"))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeHTTPError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPRequestBytes))
+	if err != nil {
+		writeHTTPError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("failed to read request body: %w", err))
+		return
+	}
+
+	req := &httpRequest{
+		w:        w,
+		subject:  subject,
+		data:     data,
+		headers:  micro.Headers(r.Header.Clone()),
+		identity: identity,
+	}
+	handler(r.Context(), req)
+	if !req.responded {
+		slog.ErrorContext(r.Context(), "Handler did not respond to HTTP request", "subject", subject)
+		writeHTTPError(w, http.StatusInternalServerError, errors.New("no response"))
+	}
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	resp := &schemas.ErrorResponse{}
+	resp.SetErrorAttributes(err, errorDetail(err, status >= http.StatusInternalServerError))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// httpRequest adapts an HTTP request to the micro.Request interface, so the endpoint handlers can serve it
+type httpRequest struct {
+	w         http.ResponseWriter
+	subject   string
+	data      []byte
+	headers   micro.Headers
+	identity  callerIdentity
+	responded bool
+}
+
+// authenticatedIdentity returns the caller identity established from the bearer token. It is used in
+// place of the request headers, which are set by the caller and so can't be trusted.
+func (r *httpRequest) authenticatedIdentity() callerIdentity {
+	return r.identity
+}
+
+// Respond writes the response. The status code is taken from the micro.ErrorCodeHeader, when the
+// response is an error.
+func (r *httpRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	if r.responded {
+		return ErrAlreadyResponded
+	}
+	r.responded = true
+
+	// Collect any headers the handler added
+	msg := nats.NewMsg(r.subject)
+	for _, opt := range opts {
+		opt(msg)
+	}
+	status := http.StatusOK
+	for key, values := range msg.Header {
+		if key == micro.ErrorCodeHeader {
+			status = httpStatus(values[0])
+		}
+		for _, value := range values {
+			r.w.Header().Add(key, value)
+		}
+	}
+	r.w.Header().Set("Content-Type", "application/json")
+	r.w.WriteHeader(status)
+	_, err := r.w.Write(data)
+	return err
+}
+
+func (r *httpRequest) RespondJSON(response any, opts ...micro.RespondOpt) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return micro.ErrMarshalResponse
+	}
+	return r.Respond(data, opts...)
+}
+
+func (r *httpRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	opts = append(opts, micro.WithHeaders(micro.Headers{
+		micro.ErrorHeader:     {description},
+		micro.ErrorCodeHeader: {code},
+	}))
+	return r.Respond(data, opts...)
+}
+
+func (r *httpRequest) Data() []byte { return r.data }
+
+func (r *httpRequest) Headers() micro.Headers { return r.headers }
+
+func (r *httpRequest) Subject() string { return r.subject }
+
+// Reply is empty, an HTTP response is written directly to the caller
+func (r *httpRequest) Reply() string { return "" }
+
+// httpStatus converts an error code into an HTTP status, codes that aren't HTTP error statuses are
+// reported as internal errors
+func httpStatus(code string) int {
+	status, err := strconv.Atoi(code)
+	if err != nil || status < 400 || status > 599 {
+		return http.StatusInternalServerError
+	}
+	return status
+}
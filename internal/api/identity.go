@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// RequestInfoHeader is added by the NATS server to requests that reach us through a service import,
+// when the service export is configured to share the requester's details (`share: true`).
+// Because the server sets it, the account it names has been authenticated. A client in the service's own
+// account sends its requests without an import, so it could set the header itself and name any account;
+// the server doesn't tell the two apart. It is a deployment requirement that only the service connects
+// with its account, and that every caller reaches it through a service import, see docs/architecture.md.
+const RequestInfoHeader = "Nats-Request-Info"
+
+var (
+	ErrNoTenant  = errors.New("request has no tenant")
+	ErrBadTenant = errors.New("invalid tenant")
+
+	// tenantPattern matches the inventory_tenant_id_format database constraint, and ensures a tenant
+	// can safely be used as a token in a NATS subject
+	tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// requestInfo is the subset of the Nats-Request-Info header that we use
+type requestInfo struct {
+	Account string `json:"acc"`
+	User    string `json:"user,omitempty"`
+}
+
+// callerIdentity describes who made a request
+type callerIdentity struct {
+	Account string
+	User    string
+	Tenant  string
+}
+
+// String identifies the caller in logs and metrics
+func (identity callerIdentity) String() string {
+	return identity.Tenant + "/" + identity.Account + "/" + identity.User
+}
+
+type identityKey struct{}
+
+// withIdentity returns a copy of ctx that carries the caller identity
+func withIdentity(ctx context.Context, identity callerIdentity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// identityFrom returns the caller identity stored in ctx, or an empty identity if there isn't one
+func identityFrom(ctx context.Context) callerIdentity {
+	identity, _ := ctx.Value(identityKey{}).(callerIdentity)
+	return identity
+}
+
+// authenticatedRequest is implemented by requests that were authenticated before they reached us,
+// such as HTTP requests that carry a bearer token
+type authenticatedRequest interface {
+	authenticatedIdentity() callerIdentity
+}
+
+// identify works out who made a request.
+// The tenant is taken from the tenantHeader when it is configured and present on a request from the
+// gatewayAccount, because that trusted gateway sets it on behalf of the real caller. Any other caller
+// could name whichever tenant it liked, so otherwise the tenant is the NATS account that made the request.
+func identify(req micro.Request, tenantHeader, gatewayAccount string) callerIdentity {
+	if authenticated, ok := unwrapRequest(req).(authenticatedRequest); ok {
+		return authenticated.authenticatedIdentity()
+	}
+	identity := callerIdentity{}
+	if header := req.Headers().Get(RequestInfoHeader); header != "" {
+		info := requestInfo{}
+		if err := json.Unmarshal([]byte(header), &info); err == nil {
+			identity.Account = info.Account
+			identity.User = info.User
+			identity.Tenant = info.Account
+		}
+	}
+	if tenantHeader != "" && gatewayAccount != "" && identity.Account == gatewayAccount {
+		// The gateway acts for its callers, it has no inventory of its own
+		identity.Tenant = req.Headers().Get(tenantHeader)
+	}
+	return identity
+}
+
+// validateTenant checks that a request has a usable tenant
+func validateTenant(tenant string) error {
+	if tenant == "" {
+		return ErrNoTenant
+	}
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("%w: %s", ErrBadTenant, tenant)
+	}
+	return nil
+}
+
+// identityMiddleware adds the caller identity to the request context
+func (app *App) identityMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		next(withIdentity(ctx, identify(req, app.config.TenantHeader, app.config.GatewayAccount)), req)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+
+	"github.com/davidoram/beaker/internal/db"
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+)
+
+// DefaultListLimit is the number of products stock.list returns when the request has no limit
+const DefaultListLimit = 100
+
+// stockList handles the stock.list endpoint
+func (app *App) stockList(ctx context.Context, rs *requestScope, req schemas.StockListRequest) *schemas.StockListResponse {
+	after, limit := "", DefaultListLimit
+	if req.After != nil {
+		after = *req.After
+	}
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+	page := rs.ListStock(ctx, after, limit)
+	if rs.HasError() {
+		return nil
+	}
+	resp := &schemas.StockListResponse{OK: true, StockLevels: []schemas.StockLevel{}}
+	for _, inventory := range page {
+		// The request names no products for the policy to check, so the products the caller may not
+		// see are left out of the page instead
+		if !app.allowedSKU(ctx, inventory.ProductSku) {
+			continue
+		}
+		resp.StockLevels = append(resp.StockLevels, schemas.StockLevel{ProductSKU: inventory.ProductSku, Quantity: int(inventory.StockLevel)})
+	}
+	// A short page is the last one. A full page may be followed by more products, the cursor is the last
+	// product read so that the products left out don't end the list early.
+	if len(page) == limit {
+		resp.NextAfter = utility.Ptr(page[len(page)-1].ProductSku)
+	}
+	return resp
+}
+
+// ListStock returns up to limit products of the tenant that sort after the SKU after, in order of their SKU
+func (rs *requestScope) ListStock(ctx context.Context, after string, limit int) []db.Inventory {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "list stock")
+	defer span.End()
+
+	if rs.HasError() {
+		return nil
+	}
+
+	page, err := rs.queries.ListInventoryPage(ctx, db.ListInventoryPageParams{TenantID: rs.tenant, AfterSku: after, PageSize: int32(limit)})
+	if err != nil {
+		rs.AddSystemError(ctx, err)
+		return nil
+	}
+	return page
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+
+	"github.com/davidoram/beaker/internal/concurrency"
+	"github.com/davidoram/beaker/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// appMetrics holds the instruments used to record the App's metrics
+type appMetrics struct {
+	// rateLimitRequests counts requests checked against the rate limits, by caller, endpoint and outcome
+	rateLimitRequests metric.Int64Counter
+	// responseSchemaViolations counts responses that did not conform to their schema, by endpoint and schema
+	responseSchemaViolations metric.Int64Counter
+	// requestDuration records how long each request took, by endpoint, API version, tenant and status
+	requestDuration metric.Float64Histogram
+	// concurrencyWait records how long requests waited for a slot under the concurrency caps, by endpoint and outcome
+	concurrencyWait metric.Float64Histogram
+}
+
+func newAppMetrics() (*appMetrics, error) {
+	meter := telemetry.GetMeter()
+	rateLimitRequests, err := meter.Int64Counter("beaker.ratelimit.requests",
+		metric.WithDescription("Requests checked against the rate limits, by caller, endpoint and outcome"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		return nil, err
+	}
+	responseSchemaViolations, err := meter.Int64Counter("beaker.response.schema_violations",
+		metric.WithDescription("Responses that did not conform to their schema, by endpoint and schema"),
+		metric.WithUnit("{response}"))
+	if err != nil {
+		return nil, err
+	}
+	requestDuration, err := meter.Float64Histogram("beaker.request.duration",
+		metric.WithDescription("How long each request took, by endpoint, API version, tenant and status"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	concurrencyWait, err := meter.Float64Histogram("beaker.concurrency.wait",
+		metric.WithDescription("How long requests waited for a slot under the concurrency caps, by endpoint and outcome"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	return &appMetrics{
+		rateLimitRequests:        rateLimitRequests,
+		responseSchemaViolations: responseSchemaViolations,
+		requestDuration:          requestDuration,
+		concurrencyWait:          concurrencyWait,
+	}, nil
+}
+
+// observeQueueDepths reports the number of requests waiting for a slot under each of the limiter's caps
+func observeQueueDepths(limiter *concurrency.Limiter) error {
+	_, err := telemetry.GetMeter().Int64ObservableGauge("beaker.concurrency.queue_depth",
+		metric.WithDescription("Requests waiting for a slot under each concurrency cap, by scope"),
+		metric.WithUnit("{request}"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			for scope, depth := range limiter.QueueDepths() {
+				o.Observe(int64(depth), metric.WithAttributes(attribute.String("beaker.scope", scope)))
+			}
+			return nil
+		}))
+	return err
+}
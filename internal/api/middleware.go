@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrPanic = errors.New("handler panicked")
+
+// Handler handles a request to an endpoint
+type Handler func(ctx context.Context, req micro.Request)
+
+// Middleware wraps a Handler with steps that run before and after it, eg: to check the caller is allowed
+// to make the request. A middleware can turn a request away by replying to it, without calling next.
+type Middleware func(next Handler) Handler
+
+// Chain wraps the handler in the middleware. The first middleware is the outermost, so it runs first.
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// middleware returns the chain that every request passes through before it reaches the endpoint's handler,
+// ending with the extra middleware from the Config
+func (app *App) middleware() []Middleware {
+	return append([]Middleware{
+		traceMiddleware,
+		recoverMiddleware,
+		app.drainMiddleware,
+		app.identityMiddleware,
+		accessLogMiddleware,
+		app.metricsMiddleware,
+		app.rateLimitMiddleware,
+		app.authorizeMiddleware,
+		app.deadlineMiddleware,
+		app.concurrencyMiddleware,
+	}, app.config.Middleware...)
+}
+
+// wrapHandler adapts the route's handler to a micro.Handler, for requests that arrive over NATS.
+// micro hands an endpoint its requests one at a time, so each request is handled on its own goroutine,
+// or the concurrency caps would never see more than one request per endpoint. Once every handler slot is
+// busy the next request waits here, and NATS holds the requests behind it.
+func (app *App) wrapHandler(r route) micro.HandlerFunc {
+	handler := app.routeHandler(r)
+	return func(req micro.Request) {
+		app.handlers <- struct{}{}
+		go func() {
+			defer func() { <-app.handlers }()
+			handler(context.Background(), req)
+		}()
+	}
+}
+
+// routeHandler adds the middleware chain, and the endpoint's own middleware, to the endpoint's handler.
+// Each request's context carries the route it arrived on.
+func (app *App) routeHandler(r route) Handler {
+	handler := Chain(r.endpoint.handler, append(app.middleware(), r.endpoint.middleware...)...)
+	return func(ctx context.Context, req micro.Request) {
+		handler(withRoute(ctx, r), req)
+	}
+}
+
+// traceMiddleware starts the span for a request. The span continues the caller's trace when the request
+// headers carry a trace context.
+func traceMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		ctx = telemetry.ExtractContext(ctx, nats.Header(req.Headers()))
+		tracer := telemetry.GetTracer()
+		ctx, span := tracer.Start(ctx, "process "+req.Subject(),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(telemetry.MessagingAttributes(semconv.MessagingOperationTypeProcess, req.Subject(), len(req.Data()))...))
+		defer span.End()
+		next(ctx, req)
+	}
+}
+
+// recoverMiddleware stops a panic in a handler from killing the service. The panic is logged, and the
+// caller is sent a system error, unless the handler had already replied. Handlers that use a requestScope
+// recover in rs.Close, so their transaction is rolled back, this catches panics anywhere else.
+func recoverMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		recorder := recordResponses(req)
+		defer func() {
+			if p := recover(); p != nil {
+				err := panicError(ctx, p)
+				if !recorder.responded {
+					replyWithError(ctx, recorder, err, true)
+				}
+			}
+		}()
+		next(ctx, recorder)
+	}
+}
+
+// panicError converts a recovered panic into an error. The panic is logged with its stack, and recorded
+// on the span, so it can be found and fixed.
+func panicError(ctx context.Context, p any) error {
+	err := fmt.Errorf("%w: %v", ErrPanic, p)
+	stack := string(debug.Stack())
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err, trace.WithAttributes(semconv.ExceptionStacktrace(stack)))
+	span.SetStatus(codes.Error, err.Error())
+	slog.ErrorContext(ctx, "system error", "error", err, "stack", stack)
+	return err
+}
+
+// accessLogMiddleware logs every request once it has been handled, with the caller, the outcome and how long it took
+func accessLogMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		recorder := recordResponses(req)
+		start := time.Now()
+		next(ctx, recorder)
+		slog.InfoContext(ctx, "API Request "+req.Subject(),
+			"caller", identityFrom(ctx).String(),
+			"status", recorder.status(),
+			"duration", time.Since(start))
+	}
+}
+
+// metricsMiddleware records how long each request took, by endpoint, API version and outcome. Requests on the
+// unversioned subjects are marked as legacy, so it is clear when those subjects can be retired.
+func (app *App) metricsMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		recorder := recordResponses(req)
+		start := time.Now()
+		next(ctx, recorder)
+		app.metrics.requestDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+			attribute.String("beaker.endpoint", endpointName(ctx, req)),
+			attribute.String("beaker.api_version", apiVersionOf(ctx)),
+			attribute.Bool("beaker.legacy_subject", isLegacyRoute(ctx)),
+			attribute.String("beaker.tenant_id", identityFrom(ctx).Tenant),
+			attribute.Int("beaker.status", recorder.status()),
+		))
+	}
+}
+
+// responseRecorder wraps a request to record the response sent to it, so middleware can act on the outcome
+type responseRecorder struct {
+	micro.Request
+	responded bool
+	// code is the error code of the response, empty for a successful response
+	code string
+}
+
+// recordResponses returns a responseRecorder for the request, reusing it if the request is already recorded
+func recordResponses(req micro.Request) *responseRecorder {
+	if recorder, ok := req.(*responseRecorder); ok {
+		return recorder
+	}
+	return &responseRecorder{Request: req}
+}
+
+// Unwrap returns the request that was wrapped
+func (r *responseRecorder) Unwrap() micro.Request {
+	return r.Request
+}
+
+// status returns the HTTP status code equivalent of the response
+func (r *responseRecorder) status() int {
+	if r.code == "" {
+		return http.StatusOK
+	}
+	return httpStatus(r.code)
+}
+
+func (r *responseRecorder) Respond(data []byte, opts ...micro.RespondOpt) error {
+	// Apply the options to a throwaway message, to see the headers they add
+	msg := nats.NewMsg(r.Subject())
+	for _, opt := range opts {
+		opt(msg)
+	}
+	r.responded = true
+	r.code = msg.Header.Get(micro.ErrorCodeHeader)
+	return r.Request.Respond(data, opts...)
+}
+
+func (r *responseRecorder) RespondJSON(response any, opts ...micro.RespondOpt) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return micro.ErrMarshalResponse
+	}
+	return r.Respond(data, opts...)
+}
+
+func (r *responseRecorder) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	r.responded = true
+	r.code = code
+	return r.Request.Error(code, description, data, opts...)
+}
+
+// unwrapRequest returns the original request, from beneath any middleware wrappers
+func unwrapRequest(req micro.Request) micro.Request {
+	for {
+		wrapper, ok := req.(interface{ Unwrap() micro.Request })
+		if !ok {
+			return req
+		}
+		req = wrapper.Unwrap()
+	}
+}
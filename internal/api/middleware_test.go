@@ -0,0 +1,278 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidoram/beaker/internal/concurrency"
+	"github.com/davidoram/beaker/schemas"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// newTestRequest returns a request that records the response in w
+func newTestRequest(subject string) (*httpRequest, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	return &httpRequest{w: w, subject: subject, headers: micro.Headers{}}, w
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	step := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, req micro.Request) {
+				calls = append(calls, name)
+				next(ctx, req)
+			}
+		}
+	}
+	handler := Chain(func(ctx context.Context, req micro.Request) {
+		calls = append(calls, "handler")
+	}, step("first"), step("second"))
+
+	req, _ := newTestRequest("stock.get")
+	handler(t.Context(), req)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	handler := recoverMiddleware(func(ctx context.Context, req micro.Request) {
+		var inventory *schemas.StockGetResponse
+		_ = *inventory.Quantity
+	})
+	req, w := newTestRequest("stock.get")
+	require.NotPanics(t, func() { handler(t.Context(), req) })
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	resp := schemas.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.NotNil(t, resp.ErrorDetail)
+	assert.Equal(t, schemas.ErrorCodeInternal, resp.ErrorDetail.Code)
+
+	// A handler that panics after it replied isn't sent a second reply
+	handler = recoverMiddleware(func(ctx context.Context, req micro.Request) {
+		require.NoError(t, req.Respond([]byte(`{"ok": true}`)))
+		panic("after reply")
+	})
+	req, w = newTestRequest("stock.get")
+	require.NotPanics(t, func() { handler(t.Context(), req) })
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"ok": true}`, w.Body.String())
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	handler := accessLogMiddleware(func(ctx context.Context, req micro.Request) {
+		rejectRequest(ctx, req, ErrWatchNotFound)
+	})
+	req, _ := newTestRequest("stock.watch.renew")
+	handler(withIdentity(t.Context(), callerIdentity{Account: "ACME", Tenant: "acme"}), req)
+
+	var entry map[string]any
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	require.NoError(t, json.Unmarshal(lines[len(lines)-1], &entry))
+	assert.Equal(t, "API Request stock.watch.renew", entry["msg"])
+	assert.Equal(t, "acme/ACME/", entry["caller"])
+	assert.Equal(t, float64(http.StatusNotFound), entry["status"])
+	assert.Contains(t, entry, "duration")
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	metrics, err := newAppMetrics()
+	require.NoError(t, err)
+	app := &App{metrics: metrics}
+
+	handler := app.metricsMiddleware(func(ctx context.Context, req micro.Request) {
+		rejectRequest(ctx, req, ErrWatchNotFound)
+	})
+	req, _ := newTestRequest("stock.watch.renew")
+	handler(t.Context(), req)
+
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(t.Context(), &rm))
+	var points []metricdata.HistogramDataPoint[float64]
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == "beaker.request.duration" {
+				points = append(points, m.Data.(metricdata.Histogram[float64]).DataPoints...)
+			}
+		}
+	}
+	require.Len(t, points, 1)
+	assert.Equal(t, uint64(1), points[0].Count)
+	status, ok := points[0].Attributes.Value(attribute.Key("beaker.status"))
+	require.True(t, ok)
+	assert.Equal(t, int64(http.StatusNotFound), status.AsInt64())
+}
+
+func TestIdentifyWrappedRequest(t *testing.T) {
+	req, _ := newTestRequest("stock.get")
+	req.identity = callerIdentity{Account: "ACME", Tenant: "acme"}
+	// The identity comes from the bearer token, even when a caller sets the tenant header
+	req.headers["Beaker-Tenant"] = []string{"someone-else"}
+
+	identity := identify(recordResponses(req), "Beaker-Tenant", "GATEWAY")
+	assert.Equal(t, req.identity, identity)
+}
+
+// natsRequest is a request that arrived over NATS, so only its headers say who sent it
+type natsRequest struct {
+	micro.Request
+	headers micro.Headers
+}
+
+func (r natsRequest) Headers() micro.Headers {
+	return r.headers
+}
+
+func TestIdentifyTenantHeader(t *testing.T) {
+	// The tenant header is honoured on requests from the gateway account
+	req := natsRequest{headers: micro.Headers{
+		RequestInfoHeader: []string{`{"acc": "GATEWAY", "user": "gw"}`},
+		"Beaker-Tenant":   []string{"acme"},
+	}}
+	assert.Equal(t, callerIdentity{Account: "GATEWAY", User: "gw", Tenant: "acme"}, identify(req, "Beaker-Tenant", "GATEWAY"))
+
+	// A request from the gateway that doesn't name a tenant has none
+	delete(req.headers, "Beaker-Tenant")
+	assert.Equal(t, callerIdentity{Account: "GATEWAY", User: "gw"}, identify(req, "Beaker-Tenant", "GATEWAY"))
+
+	// Any other account gets its own tenant, whatever it puts in the header
+	req.headers[RequestInfoHeader] = []string{`{"acc": "ACME"}`}
+	req.headers["Beaker-Tenant"] = []string{"someone-else"}
+	assert.Equal(t, callerIdentity{Account: "ACME", Tenant: "ACME"}, identify(req, "Beaker-Tenant", "GATEWAY"))
+
+	// As does a request without the request info, and every request when there is no gateway account
+	delete(req.headers, RequestInfoHeader)
+	assert.Equal(t, callerIdentity{}, identify(req, "Beaker-Tenant", "GATEWAY"))
+	req.headers[RequestInfoHeader] = []string{`{"acc": "GATEWAY"}`}
+	assert.Equal(t, callerIdentity{Account: "GATEWAY", Tenant: "GATEWAY"}, identify(req, "Beaker-Tenant", ""))
+}
+
+func TestConcurrencyMiddleware(t *testing.T) {
+	metrics, err := newAppMetrics()
+	require.NoError(t, err)
+	app := &App{metrics: metrics, config: Config{
+		Concurrency: concurrency.NewLimiter(concurrency.Config{MaxInFlight: 1, MaxWait: 10 * time.Millisecond}),
+	}}
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := app.concurrencyMiddleware(func(ctx context.Context, req micro.Request) {
+		close(started)
+		<-finish
+		require.NoError(t, req.Respond([]byte(`{"ok": true}`)))
+	})
+	first, firstW := newTestRequest("stock.get")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(t.Context(), first)
+	}()
+	<-started
+
+	// The only slot is taken, and there is no queue
+	second, w := newTestRequest("stock.get")
+	handler(t.Context(), second)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	resp := schemas.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, schemas.ErrorCodeOverloaded, resp.ErrorDetail.Code)
+
+	close(finish)
+	<-done
+	assert.Equal(t, http.StatusOK, firstW.Code)
+}
+
+func TestServeHTTPUsesRequestContext(t *testing.T) {
+	tokens := &BearerTokens{identities: map[[sha256.Size]byte]callerIdentity{
+		sha256.Sum256([]byte("secret")): {Account: "ACME", Tenant: "acme"},
+	}}
+	type key struct{}
+	var got context.Context
+	handler := func(ctx context.Context, req micro.Request) {
+		got = ctx
+		require.NoError(t, req.Respond([]byte(`{"ok": true}`)))
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(t.Context(), key{}, "from the request"))
+	r := httptest.NewRequestWithContext(ctx, http.MethodPost, "/stock/get", strings.NewReader(`{}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	serveHTTP(w, r, tokens, "stock.get", handler)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The handler's context is cancelled when the HTTP request's is
+	require.NotNil(t, got)
+	assert.Equal(t, "from the request", got.Value(key{}))
+	cancel()
+	assert.ErrorIs(t, got.Err(), context.Canceled)
+}
+
+func TestConcurrencyOverNATS(t *testing.T) {
+	ns := natsserver.RunRandClientPortServer()
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	metrics, err := newAppMetrics()
+	require.NoError(t, err)
+	app := &App{metrics: metrics, requests: newRequestTracker(), handlers: make(chan struct{}, DefaultMaxHandlers), config: Config{
+		Concurrency: concurrency.NewLimiter(concurrency.Config{MaxInFlight: 1, MaxWait: 10 * time.Millisecond}),
+	}}
+	started := make(chan struct{}, 1)
+	finish := make(chan struct{})
+	r := app.routes()[0]
+	r.endpoint.middleware = nil
+	r.endpoint.handler = func(ctx context.Context, req micro.Request) {
+		started <- struct{}{}
+		<-finish
+		require.NoError(t, req.Respond([]byte(`{"ok": true}`)))
+	}
+	svc, err := micro.AddService(nc, micro.Config{Name: "ConcurrencyTest", Version: "0.1.0"})
+	require.NoError(t, err)
+	defer svc.Stop() // nolint:errcheck
+	require.NoError(t, svc.AddEndpoint(r.name(), app.wrapHandler(r), micro.WithEndpointSubject(r.subject)))
+
+	first := make(chan *nats.Msg)
+	go func() {
+		reply, err := nc.Request(r.subject, []byte(`{}`), 5*time.Second)
+		assert.NoError(t, err)
+		first <- reply
+	}()
+	<-started
+
+	// The second request reaches the limiter while the first is still being handled, rather than
+	// waiting behind it, and is turned away
+	reply, err := nc.Request(r.subject, []byte(`{}`), time.Second)
+	require.NoError(t, err)
+	resp := schemas.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(reply.Data, &resp))
+	require.NotNil(t, resp.ErrorDetail)
+	assert.Equal(t, schemas.ErrorCodeOverloaded, resp.ErrorDetail.Code)
+
+	close(finish)
+	assert.JSONEq(t, `{"ok": true}`, string((<-first).Data))
+}
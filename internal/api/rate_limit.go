@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"github.com/nats-io/nats.go/micro"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// RetryAfterHeader is added to responses for rate limited requests, it holds the number of seconds
+// the caller should wait before trying again
+const RetryAfterHeader = "Retry-After"
+
+// rateLimitMiddleware rejects requests once the caller has used up their allowance for the endpoint.
+// It runs before the handler, so a rate limited request never acquires a database connection.
+// When no rate limiter is configured every request is allowed.
+func (app *App) rateLimitMiddleware(next Handler) Handler {
+	if app.config.RateLimiter == nil {
+		return next
+	}
+	return func(ctx context.Context, req micro.Request) {
+		identity := identityFrom(ctx)
+		retryAfter, err := app.config.RateLimiter.Allow(identity.String(), endpointName(ctx, req))
+
+		outcome := "allowed"
+		if err != nil {
+			outcome = "limited"
+		}
+		app.metrics.rateLimitRequests.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("beaker.caller", identity.String()),
+			attribute.String("beaker.tenant_id", identity.Tenant),
+			attribute.String("beaker.endpoint", endpointName(ctx, req)),
+			attribute.String("beaker.outcome", outcome),
+		))
+		if err != nil {
+			seconds := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+			rejectRequest(ctx, req, err, micro.WithHeaders(micro.Headers{RetryAfterHeader: {seconds}}))
+			return
+		}
+		next(ctx, req)
+	}
+}
@@ -12,19 +12,28 @@ import (
 	"github.com/davidoram/beaker/schemas"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/nats-io/nats.go/micro"
 )
 
-func (app *App) stockRemoveHandler(ctx context.Context, req micro.Request) {
-	rs := NewRequestScope(ctx, req, app.nc, app.db)
-	defer rs.Close(ctx)
-	rs.ValidateJSON(ctx, app.compiler, req.Data(), schemas.StockRemoveRequestSchema)
-	stockReq := DecodeRequest[schemas.StockRemoveRequest](ctx, rs)
-	updatedInventory := rs.RemoveStock(ctx, stockReq)
-	rs.EmitLowStockEvent(ctx, updatedInventory)
-	resp := rs.MakeStockRemoveResponse(ctx, updatedInventory)
-	rs.CommitOrRollback(ctx)
-	rs.RespondJSON(ctx, req, resp)
+var (
+	ErrInsufficientStock = errors.New("stock level cannot go below zero")
+	ErrInvalidSKU        = errors.New("invalid SKU format")
+	ErrBusinessRule      = errors.New("business rule violated")
+)
+
+// stockRemove handles the stock.remove endpoint
+func (app *App) stockRemove(ctx context.Context, rs *requestScope, req schemas.StockRemoveRequest) *schemas.StockRemoveResponse {
+	req.ProductSKU = app.resolveSKU(ctx, rs, req.ProductSKU)
+	updatedInventory := rs.RemoveStock(ctx, req)
+	rs.EmitStockChangedEvent(ctx, app.schemas, schemas.StockOperationRemove, -req.Quantity, updatedInventory)
+	rs.EmitLowStockEvent(ctx, app.schemas, updatedInventory)
+	if rs.HasError() {
+		return nil
+	}
+	return &schemas.StockRemoveResponse{
+		OK:         true,
+		ProductSKU: utility.Ptr(updatedInventory.ProductSku),
+		Quantity:   utility.Ptr(int(updatedInventory.StockLevel)),
+	}
 }
 
 // RemoveStock adds stock to the inventory.
@@ -38,6 +47,7 @@ func (rs *requestScope) RemoveStock(ctx context.Context, req schemas.StockRemove
 	}
 
 	params := db.RemoveInventoryParams{
+		TenantID:   rs.tenant,
 		ProductSku: req.ProductSKU,
 		StockLevel: int32(req.Quantity),
 	}
@@ -51,11 +61,11 @@ func (rs *requestScope) RemoveStock(ctx context.Context, req schemas.StockRemove
 				// Branch by constraint name
 				switch pgErr.ConstraintName {
 				case "inventory_stock_level_nonnegative":
-					rs.AddCallerError(ctx, fmt.Errorf("stock level cannot go below zero for %s", req.ProductSKU))
+					rs.AddCallerError(ctx, fmt.Errorf("%w for %s", ErrInsufficientStock, req.ProductSKU))
 				case "inventory_product_sku_format":
-					rs.AddCallerError(ctx, fmt.Errorf("invalid SKU format: %s", req.ProductSKU))
+					rs.AddCallerError(ctx, fmt.Errorf("%w: %s", ErrInvalidSKU, req.ProductSKU))
 				default:
-					rs.AddCallerError(ctx, fmt.Errorf("business rule violated: %s", pgErr.Message))
+					rs.AddCallerError(ctx, fmt.Errorf("%w: %s", ErrBusinessRule, pgErr.Message))
 				}
 				return nil
 			}
@@ -65,20 +75,3 @@ func (rs *requestScope) RemoveStock(ctx context.Context, req schemas.StockRemove
 	}
 	return &inventory
 }
-
-func (rs *requestScope) MakeStockRemoveResponse(ctx context.Context, inventory *db.Inventory) *schemas.StockRemoveResponse {
-	tracer := telemetry.GetTracer()
-	_, span := tracer.Start(ctx, "build stock-remove response")
-	defer span.End()
-
-	resp := schemas.StockRemoveResponse{}
-	if rs.HasError() {
-		resp.OK = false
-		resp.Error = utility.Ptr(rs.GetError().Error())
-	} else {
-		resp.OK = true
-		resp.ProductSKU = utility.Ptr(inventory.ProductSku)
-		resp.Quantity = utility.Ptr(int(inventory.StockLevel))
-	}
-	return &resp
-}
@@ -1,27 +1,33 @@
 package api
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"log/slog"
 
 	"github.com/davidoram/beaker/internal/db"
 	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/utility"
 	"github.com/davidoram/beaker/schemas"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
-	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const LowStockThreshold = 10
 
+// RequestIDHeader is the request header a caller can use to supply their own request ID.
+// If it is not set a new ID is generated for each request.
+const RequestIDHeader = "Request-Id"
+
 // requestScope holds the context for a single request.
 // It holds the request and any errors that occur during processing.
 // When the API receives a call it should create a NewRequestScope instance
@@ -31,26 +37,79 @@ const LowStockThreshold = 10
 // act appropriately.
 // This allows for early exit from the function without further processing
 type requestScope struct {
-	nc  *nats.Conn
-	req micro.Request
-	err error
+	nc        *nats.Conn
+	req       micro.Request
+	requestID string
+	tenant    string
+	err       error
+	// errIsSystem is true when err is a system error
+	errIsSystem bool
 
 	conn    *pgxpool.Conn
 	tx      pgx.Tx
 	queries *db.Queries
+
+	// events emitted during the request, published once the transaction commits
+	events []*nats.Msg
+
+	// responded is true once RespondJSON has replied to the caller
+	responded bool
 }
 
 // NewRequestScope creates a new requestScope instance. It should be paired with a call to rs.Close(ctx) to guarantee cleanup.
+// Pass a nil pool for requests that do not use the database.
+// Every request must belong to a tenant, requests without one are rejected before a database connection is acquired.
 func NewRequestScope(ctx context.Context, req micro.Request, nc *nats.Conn, pool *pgxpool.Pool) *requestScope {
+	return newRequestScope(ctx, req, nc, pool, pgx.ReadWrite)
+}
+
+// newRequestScope creates a new requestScope instance, whose transaction has the access mode
+func newRequestScope(ctx context.Context, req micro.Request, nc *nats.Conn, pool *pgxpool.Pool, accessMode pgx.TxAccessMode) *requestScope {
 	rs := &requestScope{
-		req: req,
-		nc:  nc,
+		req:       req,
+		nc:        nc,
+		requestID: requestID(req),
+		tenant:    identityFrom(ctx).Tenant,
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("beaker.request_id", rs.requestID),
+		attribute.String("beaker.tenant_id", rs.tenant),
+	)
+	if err := validateTenant(rs.tenant); err != nil {
+		rs.AddCallerError(ctx, err)
+		return rs
+	}
+	if pool != nil {
+		rs.setupDbConn(ctx, pool, accessMode)
 	}
-	rs.setupDbConn(ctx, pool)
 	return rs
 }
 
+// requestID returns the caller supplied request ID, or a new one if the caller didn't supply one
+func requestID(req micro.Request) string {
+	if id := req.Headers().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+// Close commits or rolls back the transaction, and releases the database connection.
+// It must be deferred, so that it can recover from a panic in the handler. After a panic the transaction
+// is rolled back, and the caller is sent a system error, unless they have already had a response.
 func (rs *requestScope) Close(ctx context.Context) {
+	var panicErr error
+	if p := recover(); p != nil {
+		panicErr = panicError(ctx, p)
+		rs.AddSystemError(ctx, panicErr)
+	}
+	rs.releaseDbConn(ctx)
+	if panicErr != nil && !rs.responded {
+		replyWithError(ctx, rs.req, panicErr, true)
+	}
+}
+
+// releaseDbConn ends the transaction and returns the connection to the pool
+func (rs *requestScope) releaseDbConn(ctx context.Context) {
 	if rs.conn == nil {
 		return
 	}
@@ -62,8 +121,9 @@ func (rs *requestScope) Close(ctx context.Context) {
 }
 
 // setupDbConn establishes a connection through the pgxpool.Pool, and wraps it into a Queries instance
-// which is then able to be used to access the database
-func (rs *requestScope) setupDbConn(ctx context.Context, pool *pgxpool.Pool) {
+// which is then able to be used to access the database. The transaction is scoped to the request's tenant
+// so the row level security policies only allow access to that tenant's rows.
+func (rs *requestScope) setupDbConn(ctx context.Context, pool *pgxpool.Pool, accessMode pgx.TxAccessMode) {
 	tracer := telemetry.GetTracer()
 	ctx, span := tracer.Start(ctx, "setup db conn")
 	defer span.End()
@@ -75,13 +135,17 @@ func (rs *requestScope) setupDbConn(ctx context.Context, pool *pgxpool.Pool) {
 		return
 	}
 	rs.conn = conn
-	rs.tx, err = conn.Begin(ctx)
+	rs.tx, err = conn.BeginTx(ctx, pgx.TxOptions{AccessMode: accessMode})
 	if err != nil {
 		rs.AddSystemError(ctx, err)
 		span.SetStatus(codes.Error, err.Error())
 		return
 	}
 	rs.queries = db.New(rs.tx)
+	if err := rs.queries.SetTenant(ctx, rs.tenant); err != nil {
+		rs.AddSystemError(ctx, err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 }
 
 // AddCallerError adds a 'caller' error to the request scope which represents a problem made by
@@ -111,8 +175,13 @@ func (rs *requestScope) addError(ctx context.Context, err error, isSystemError b
 		slog.InfoContext(ctx, "caller error", "error", err)
 	}
 
+	// The underlying error is usually a cancelled database call, which doesn't tell the caller why
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && !errors.Is(err, ErrRequestTimeout) {
+		err = fmt.Errorf("%w: %w", ErrRequestTimeout, err)
+	}
 	if rs.err == nil {
 		rs.err = err
+		rs.errIsSystem = isSystemError
 	}
 }
 
@@ -123,7 +192,8 @@ func (rs *requestScope) GetError() error { return rs.err }
 // ValidateRequest checks if the request is valid.
 // It checks if the request is nil and if the request method is valid.
 // If the request is invalid, it adds an error to the request scope.
-func (rs *requestScope) ValidateJSON(ctx context.Context, compiler *jsonschema.Compiler, jsonData []byte, schemaName string) {
+// The schema is taken from the registry, a schema that isn't registered is a system error.
+func (rs *requestScope) ValidateJSON(ctx context.Context, registry *utility.SchemaRegistry, jsonData []byte, schemaName string) {
 	tracer := telemetry.GetTracer()
 	ctx, span := tracer.Start(ctx, "validate JSON")
 	defer span.End()
@@ -135,33 +205,12 @@ func (rs *requestScope) ValidateJSON(ctx context.Context, compiler *jsonschema.C
 		rs.AddCallerError(ctx, errors.New("JSON data is empty"))
 		return
 	}
-	if compiler == nil {
-		rs.AddSystemError(ctx, errors.New("JSON schema compiler is not initialized"))
-		return
-	}
-	schema, err := compiler.Compile(schemaName)
-	if err != nil {
-		rs.AddSystemError(ctx, fmt.Errorf("failed to compile schema %s: %w", schemaName, err))
-		return
-	}
-
-	if schema == nil {
-		rs.AddSystemError(ctx, fmt.Errorf("schema %s not found", schemaName))
-		return
-	}
-
-	var data any
-	data, err = jsonschema.UnmarshalJSON(bytes.NewReader(jsonData))
-	if err != nil {
-		rs.AddCallerError(ctx, fmt.Errorf("failed to unmarshal JSON data: %w", err))
-		return
-	}
-
-	// Validate the data against the schema
-	err = schema.Validate(data)
-	if err != nil {
-		rs.AddCallerError(ctx, fmt.Errorf("JSON data does not conform to schema %s: %w", schemaName, err))
-		return
+	err := validateJSON(registry, schemaName, jsonData)
+	switch {
+	case errors.Is(err, ErrNoSchemaRegistry), errors.Is(err, utility.ErrUnknownSchema):
+		rs.AddSystemError(ctx, err)
+	case err != nil:
+		rs.AddCallerError(ctx, err)
 	}
 }
 
@@ -193,6 +242,7 @@ func (rs *requestScope) CommitOrRollback(ctx context.Context) {
 
 	// No transaction -> nothing to commit or rollback
 	if rs.tx == nil {
+		rs.publishEvents(ctx)
 		return
 	}
 
@@ -207,54 +257,120 @@ func (rs *requestScope) CommitOrRollback(ctx context.Context) {
 	// The transaction is unavailable after calling this function
 	defer func() { rs.tx = nil }()
 
-	// If we encountered an error during the request we need to roll back the transaction
+	// A request that has run past its deadline isn't committed, the caller has stopped waiting for it
+	if err := ctx.Err(); err != nil {
+		rs.AddSystemError(ctx, err)
+	}
+
+	// If we encountered an error during the request we need to roll back the transaction.
+	// The rollback must run even when the request's deadline has passed, so it isn't cancelled with the request.
 	if rs.HasError() {
-		err := rs.tx.Rollback(ctx)
+		err := rs.tx.Rollback(context.WithoutCancel(ctx))
 		if err != nil {
 			rs.AddSystemError(ctx, err)
 		}
 		return
 	}
 
-	// No errors, so commit the transaction
-	err := rs.tx.Commit(ctx)
+	// No errors, so commit the transaction. The deadline was checked above, once the commit has started it
+	// isn't cancelled, or the caller couldn't tell whether the change was made.
+	err := rs.tx.Commit(context.WithoutCancel(ctx))
 	if err != nil {
 		rs.AddSystemError(ctx, err)
+		return
 	}
+	rs.publishEvents(ctx)
 }
 
-func (rs *requestScope) RespondJSON(ctx context.Context, req micro.Request, response schemas.APIResponse) {
+// RespondJSON sends the response to the caller. A sample of responses are checked against the response
+// schema by the validator, pass a nil validator to skip the check.
+func (rs *requestScope) RespondJSON(ctx context.Context, req micro.Request, validator *responseValidator, response schemas.APIResponse) {
 	tracer := telemetry.GetTracer()
-	_, span := tracer.Start(ctx, "respond JSON")
+	ctx, span := tracer.Start(ctx, "respond JSON")
 	defer span.End()
+	var opts []micro.RespondOpt
 	if rs.HasError() {
 		slog.ErrorContext(ctx, "Request has error", "error", rs.GetError())
-		response.SetErrorAttributes(rs.GetError())
+		response.SetErrorAttributes(rs.GetError(), errorDetail(rs.GetError(), rs.errIsSystem))
+		opts = append(opts, withErrorCode(rs.GetError(), rs.errIsSystem))
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		slog.ErrorContext(ctx, "Failed to marshal response", "error", err)
+		return
+	}
+	if validator != nil {
+		validator.check(ctx, req.Subject(), response.Schema(), data)
 	}
-	err := req.RespondJSON(response)
+	rs.responded = true
+	err = req.Respond(data, opts...)
 	if err != nil {
-		response.SetErrorAttributes(rs.GetError())
 		slog.ErrorContext(ctx, "RespondJSON returned error", "error", err)
 	}
 }
 
-func (rs *requestScope) EmitEvent(ctx context.Context, event schemas.LowStockEvent) error {
-	log.Printf("Emitting low stock event: %+v", event)
+// EmitEvent queues an event to be published when the request completes. Events are only published
+// once the transaction has committed, so consumers never hear about a change that was rolled back.
+// The event is validated against its schema, and wrapped in a CloudEvents envelope.
+func (rs *requestScope) EmitEvent(ctx context.Context, registry *utility.SchemaRegistry, event schemas.Event) {
 	tracer := telemetry.GetTracer()
-	_, span := tracer.Start(ctx, "emit low stock event")
+	ctx, span := tracer.Start(ctx, "emit event")
 	defer span.End()
-	slog.InfoContext(ctx, "Emitting low stock event", "event", event)
+
+	if rs.HasError() {
+		return
+	}
+	slog.InfoContext(ctx, "Emitting event", "subject", event.Subject(), "type", event.Type(), "event", event)
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
+		rs.AddSystemError(ctx, fmt.Errorf("failed to marshal event for %s: %w", event.Subject(), err))
+		return
+	}
+	// An event that breaks its schema is a bug in our code, so it is a system error
+	if err := validateJSON(registry, event.DataSchema(), eventJSON); err != nil {
+		rs.AddSystemError(ctx, fmt.Errorf("event %s: %w", event.Type(), err))
+		return
+	}
+	rs.events = append(rs.events, newCloudEventMsg(event, eventJSON))
+}
+
+// publishEvents publishes the events queued by EmitEvent, or discards them if the request failed.
+// The database changes have already been committed by the time we get here, so a failure to publish
+// is logged rather than returned to the caller.
+func (rs *requestScope) publishEvents(ctx context.Context) {
+	events := rs.events
+	rs.events = nil
+	if rs.HasError() || len(events) == 0 {
+		return
+	}
+
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "publish events")
+	defer span.End()
+
+	for _, msg := range events {
+		rs.publishEvent(ctx, msg)
+	}
+}
+
+// publishEvent publishes an event, with the trace context in its headers so consumers can continue the trace
+func (rs *requestScope) publishEvent(ctx context.Context, msg *nats.Msg) {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "send "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(telemetry.MessagingAttributes(semconv.MessagingOperationTypeSend, msg.Subject, len(msg.Data))...))
+	defer span.End()
+
+	telemetry.InjectContext(ctx, msg.Header)
+	if err := rs.nc.PublishMsg(msg); err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		slog.ErrorContext(ctx, "Failed to marshal low stock event", "error", err)
-		return err
+		slog.ErrorContext(ctx, "Failed to publish event", "subject", msg.Subject, "error", err)
 	}
-	return rs.nc.Publish(event.Subject(), []byte(eventJSON))
 }
 
 // EmitLowStockEvent checks if the updated inventory is below the low stock threshold
-func (rs *requestScope) EmitLowStockEvent(ctx context.Context, updatedInventory *db.Inventory) {
+func (rs *requestScope) EmitLowStockEvent(ctx context.Context, registry *utility.SchemaRegistry, updatedInventory *db.Inventory) {
 
 	if rs.HasError() {
 		return
@@ -262,11 +378,29 @@ func (rs *requestScope) EmitLowStockEvent(ctx context.Context, updatedInventory
 	// If stock was successfully removed and is now low, emit a LowStockEvent
 	if updatedInventory.StockLevel < LowStockThreshold {
 		event := schemas.LowStockEvent{
+			TenantID:   updatedInventory.TenantID,
 			ProductSKU: updatedInventory.ProductSku,
 			StockLevel: int(updatedInventory.StockLevel),
 		}
-		if err := rs.EmitEvent(ctx, event); err != nil {
-			rs.AddSystemError(ctx, err)
-		}
+		rs.EmitEvent(ctx, registry, event)
+	}
+}
+
+// EmitStockChangedEvent emits a StockChangedEvent describing how an operation changed the inventory.
+// delta is the change in stock level, negative when stock was removed.
+func (rs *requestScope) EmitStockChangedEvent(ctx context.Context, registry *utility.SchemaRegistry, operation schemas.StockOperation, delta int, updatedInventory *db.Inventory) {
+	if rs.HasError() {
+		return
+	}
+	newLevel := int(updatedInventory.StockLevel)
+	event := schemas.StockChangedEvent{
+		TenantID:   updatedInventory.TenantID,
+		ProductSKU: updatedInventory.ProductSku,
+		Operation:  operation,
+		Delta:      delta,
+		OldLevel:   newLevel - delta,
+		NewLevel:   newLevel,
+		RequestID:  rs.requestID,
 	}
+	rs.EmitEvent(ctx, registry, event)
 }
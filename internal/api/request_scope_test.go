@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestScopeClosePanic(t *testing.T) {
+	req, w := newTestRequest("stock.get")
+	ctx := withIdentity(t.Context(), callerIdentity{Account: "ACME", Tenant: "acme"})
+
+	var rs *requestScope
+	handler := func(ctx context.Context) {
+		rs = NewRequestScope(ctx, req, nil, nil)
+		defer rs.Close(ctx)
+		var inventory *schemas.StockGetResponse
+		_ = *inventory.Quantity
+	}
+	require.NotPanics(t, func() { handler(ctx) })
+
+	require.ErrorIs(t, rs.GetError(), ErrPanic)
+	assert.True(t, rs.errIsSystem)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	resp := schemas.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.False(t, resp.OK)
+	require.NotNil(t, resp.ErrorDetail)
+	assert.Equal(t, schemas.ErrorCodeInternal, resp.ErrorDetail.Code)
+	assert.Equal(t, schemas.ErrorTypeSystem, resp.ErrorDetail.Type)
+}
+
+func TestRequestScopeClosePanicAfterResponse(t *testing.T) {
+	req, w := newTestRequest("stock.get")
+	ctx := withIdentity(t.Context(), callerIdentity{Account: "ACME", Tenant: "acme"})
+
+	handler := func(ctx context.Context) {
+		rs := NewRequestScope(ctx, req, nil, nil)
+		defer rs.Close(ctx)
+		rs.RespondJSON(ctx, req, nil, &schemas.StockGetResponse{OK: true})
+		panic("after the response")
+	}
+	require.NotPanics(t, func() { handler(ctx) })
+
+	// The caller only gets the first response
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"ok": true}`, w.Body.String())
+}
+
+func TestRequestScopeValidateJSON(t *testing.T) {
+	compiler, err := utility.NewJSONSchemaCompiler(t.Context(), "../../schemas")
+	require.NoError(t, err)
+	registry, err := utility.NewSchemaRegistry(compiler, schemas.StockGetRequestSchema)
+	require.NoError(t, err)
+	req, _ := newTestRequest("stock.get")
+	ctx := withIdentity(t.Context(), callerIdentity{Account: "ACME", Tenant: "acme"})
+
+	rs := NewRequestScope(ctx, req, nil, nil)
+	rs.ValidateJSON(ctx, registry, []byte(`{"product-sku": "coffee-cup"}`), schemas.StockGetRequestSchema)
+	require.NoError(t, rs.GetError())
+
+	// A request that breaks its schema is the caller's fault
+	rs = NewRequestScope(ctx, req, nil, nil)
+	rs.ValidateJSON(ctx, registry, []byte(`{"product-sku": ""}`), schemas.StockGetRequestSchema)
+	require.Error(t, rs.GetError())
+	assert.False(t, rs.errIsSystem)
+
+	// A schema that isn't registered is ours
+	rs = NewRequestScope(ctx, req, nil, nil)
+	rs.ValidateJSON(ctx, registry, []byte(`{"product-sku": "coffee-cup"}`), schemas.StockAddRequestSchema)
+	require.ErrorIs(t, rs.GetError(), utility.ErrUnknownSchema)
+	assert.True(t, rs.errIsSystem)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/davidoram/beaker/internal/db"
+	"github.com/davidoram/beaker/internal/sku"
+	"github.com/davidoram/beaker/internal/telemetry"
+)
+
+// resolveSKU resolves the product SKU named in a request, see resolveSKUs
+func (app *App) resolveSKU(ctx context.Context, rs *requestScope, productSKU string) string {
+	resolved := app.resolveSKUs(ctx, rs, []string{productSKU})
+	if len(resolved) == 0 {
+		return ""
+	}
+	return resolved[0]
+}
+
+// resolveSKUs resolves the product SKUs named in a request to the canonical SKUs the inventory is kept
+// under, and checks the caller may use those products. The products are authorized here rather than by
+// the middleware, so an alias can't be used to reach a product the caller's roles don't allow.
+func (app *App) resolveSKUs(ctx context.Context, rs *requestScope, productSKUs []string) []string {
+	resolved := rs.ResolveSKUs(ctx, app.config.SKUPolicy, productSKUs)
+	if rs.HasError() {
+		return nil
+	}
+	if err := app.authorizeSKUs(ctx, resolved); err != nil {
+		rs.AddCallerError(ctx, err)
+		return nil
+	}
+	return resolved
+}
+
+// ResolveSKUs puts each product SKU into canonical form with the policy, then replaces the aliases with
+// the SKU they stand for, keeping the order they were requested in. A SKU that isn't an alias, and isn't
+// in canonical form either, is a caller error.
+func (rs *requestScope) ResolveSKUs(ctx context.Context, policy sku.Policy, productSKUs []string) []string {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "resolve skus")
+	defer span.End()
+
+	if rs.HasError() {
+		return nil
+	}
+
+	canonical := make([]string, len(productSKUs))
+	for i, productSKU := range productSKUs {
+		canonical[i] = policy.Canonical(productSKU)
+	}
+	aliases, err := rs.queries.ResolveSkuAliases(ctx, db.ResolveSkuAliasesParams{TenantID: rs.tenant, Aliases: canonical})
+	if err != nil {
+		rs.AddSystemError(ctx, err)
+		return nil
+	}
+	resolved := make(map[string]string, len(aliases))
+	for _, alias := range aliases {
+		resolved[alias.Alias] = alias.ProductSku
+	}
+	for i, productSKU := range canonical {
+		if target, ok := resolved[productSKU]; ok {
+			canonical[i] = target
+			continue
+		}
+		// Checked here, the database would only reject the SKU when it is written
+		if !sku.Valid(productSKU) {
+			rs.AddCallerError(ctx, fmt.Errorf("%w: %q (canonical %q) is not an alias, and SKUs may only hold lower case letters, digits, hyphens and underscores", ErrInvalidSKU, productSKUs[i], productSKU))
+			return nil
+		}
+	}
+	return canonical
+}
+
+// AddSkuAlias makes an alias stand for a product SKU, replacing an alias that already exists. The alias is
+// put into canonical form with the policy first, so it is found however a caller writes it. Use the policy
+// that the service is started with.
+func AddSkuAlias(ctx context.Context, queries *db.Queries, policy sku.Policy, arg db.AddSkuAliasParams) error {
+	arg.Alias = policy.Canonical(arg.Alias)
+	return queries.AddSkuAlias(ctx, arg)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// responseValidator checks that a sample of the responses we send conform to their schemas,
+// so a handler bug that breaks the API contract is noticed
+type responseValidator struct {
+	registry *utility.SchemaRegistry
+	// sampleRate is the fraction of responses that are validated, 0 turns validation off, 1 validates every response
+	sampleRate float64
+	metrics    *appMetrics
+}
+
+// sampled reports whether the next response should be validated
+func (v *responseValidator) sampled() bool {
+	return v.sampleRate >= 1 || (v.sampleRate > 0 && rand.Float64() < v.sampleRate)
+}
+
+// check validates a sample of the responses sent to subject. A response that breaks its schema is
+// a bug in our code, so it is logged as a system error, and counted in the metrics. The response
+// is still sent, because the caller is better served by a slightly wrong response than none.
+func (v *responseValidator) check(ctx context.Context, subject, schemaName string, data []byte) {
+	if !v.sampled() {
+		return
+	}
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "validate response")
+	defer span.End()
+
+	err := validateJSON(v.registry, schemaName, data)
+	if err == nil {
+		return
+	}
+	span.SetStatus(codes.Error, err.Error())
+	slog.ErrorContext(ctx, "system error", "error", err, "subject", subject, "schema", schemaName, "violations", schemaViolations(err))
+	v.metrics.responseSchemaViolations.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("beaker.endpoint", subject),
+		attribute.String("beaker.schema", schemaName),
+	))
+}
+
+// ErrNoSchemaRegistry is returned by validateJSON when there are no schemas to validate against
+var ErrNoSchemaRegistry = errors.New("JSON schema registry is not initialized")
+
+// validateJSON checks that data conforms to the registered schema. It validates requests, responses and
+// events alike. A missing registry or an unregistered schema is a bug in our code, whichever is
+// validated, see ErrNoSchemaRegistry and utility.ErrUnknownSchema.
+func validateJSON(registry *utility.SchemaRegistry, schemaName string, data []byte) error {
+	if registry == nil {
+		return ErrNoSchemaRegistry
+	}
+	schema, err := registry.Get(schemaName)
+	if err != nil {
+		return err
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON data: %w", err)
+	}
+	if err := schema.Validate(instance); err != nil {
+		return fmt.Errorf("JSON data does not conform to schema %s: %w", schemaName, err)
+	}
+	return nil
+}
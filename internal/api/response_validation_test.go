@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestResponseValidator(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	metrics, err := newAppMetrics()
+	require.NoError(t, err)
+
+	compiler, err := utility.NewJSONSchemaCompiler(t.Context(), "../../schemas")
+	require.NoError(t, err)
+	registry, err := utility.NewSchemaRegistry(compiler, schemas.StockGetResponseSchema)
+	require.NoError(t, err)
+	validator := &responseValidator{registry: registry, sampleRate: 1, metrics: metrics}
+
+	valid := []byte(`{"ok": true, "product-sku": "coffee-cup", "quantity": 3}`)
+	validator.check(t.Context(), "stock.get", schemas.StockGetResponseSchema, valid)
+	require.Equal(t, int64(0), violations(t, reader))
+
+	resp := &schemas.StockGetResponse{}
+	resp.SetErrorAttributes(ErrInsufficientStock, errorDetail(ErrInsufficientStock, false))
+	failed, err := json.Marshal(resp)
+	require.NoError(t, err)
+	validator.check(t.Context(), "stock.get", schemas.StockGetResponseSchema, failed)
+	require.Equal(t, int64(0), violations(t, reader))
+
+	// A successful response must include the quantity
+	invalid := []byte(`{"ok": true, "product-sku": "coffee-cup"}`)
+	validator.check(t.Context(), "stock.get", schemas.StockGetResponseSchema, invalid)
+	require.Equal(t, int64(1), violations(t, reader))
+
+	err = validateJSON(registry, schemas.StockGetResponseSchema, invalid)
+	require.Error(t, err)
+	// Every branch of the oneOf is reported, including the missing quantity of the success branch
+	require.Contains(t, schemaViolations(err), "/quantity")
+
+	// Responses that aren't sampled aren't checked
+	validator.sampleRate = 0
+	validator.check(t.Context(), "stock.get", schemas.StockGetResponseSchema, invalid)
+	require.Equal(t, int64(1), violations(t, reader))
+}
+
+// violations returns the total of the response schema violations metric
+func violations(t *testing.T, reader sdkmetric.Reader) int64 {
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(t.Context(), &rm))
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "beaker.response.schema_violations" {
+				continue
+			}
+			total := int64(0)
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				total += point.Value
+			}
+			return total
+		}
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// requestTracker counts the requests in flight, so shutdown can wait for them to finish
+type requestTracker struct {
+	mu       sync.Mutex
+	active   int
+	draining bool
+	// idle is closed once the tracker is draining, and there are no requests in flight
+	idle chan struct{}
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{idle: make(chan struct{})}
+}
+
+// start records that a request is in flight. It returns false once the tracker is draining, and the
+// request should be turned away.
+func (t *requestTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.active++
+	return true
+}
+
+// finish records that a request is no longer in flight
+func (t *requestTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.draining && t.active == 0 {
+		close(t.idle)
+	}
+}
+
+// drain stops new requests from starting, and returns a channel that is closed once the requests in
+// flight have finished
+func (t *requestTracker) drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.draining {
+		t.draining = true
+		if t.active == 0 {
+			close(t.idle)
+		}
+	}
+	return t.idle
+}
+
+// isDraining reports whether shutdown has started
+func (t *requestTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// inFlight returns the number of requests in flight
+func (t *requestTracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// drainMiddleware tracks the requests in flight, and turns requests away once shutdown has started,
+// so the caller can retry with another instance of the service
+func (app *App) drainMiddleware(next Handler) Handler {
+	return func(ctx context.Context, req micro.Request) {
+		if !app.requests.start() {
+			replyWithError(ctx, req, ErrShuttingDown, true)
+			return
+		}
+		defer app.requests.finish()
+		next(ctx, req)
+	}
+}
+
+// Shutdown stops the App gracefully. It stops accepting requests, then waits until the requests in
+// flight have finished, or ctx is done. Events are published as each request completes, so once the
+// requests have finished no events are waiting to be published, although the NATS connection may still
+// need to be flushed.
+func (app *App) Shutdown(ctx context.Context) error {
+	idle := app.requests.drain()
+	stopErr := app.svc.Stop()
+
+	var waitErr error
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		waitErr = fmt.Errorf("%d requests still in flight: %w", app.requests.inFlight(), ctx.Err())
+	}
+	app.watches.Stop()
+	return errors.Join(stopErr, waitErr)
+}
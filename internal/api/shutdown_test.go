@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestTracker(t *testing.T) {
+	tracker := newRequestTracker()
+	require.True(t, tracker.start())
+	require.True(t, tracker.start())
+
+	idle := tracker.drain()
+	assert.True(t, tracker.isDraining())
+	assert.False(t, tracker.start(), "requests can't start once draining")
+
+	tracker.finish()
+	select {
+	case <-idle:
+		t.Fatal("idle while a request is in flight")
+	default:
+	}
+	tracker.finish()
+	<-idle
+	assert.Equal(t, 0, tracker.inFlight())
+
+	// Draining again is harmless
+	<-tracker.drain()
+}
+
+func TestDrainMiddleware(t *testing.T) {
+	app := &App{requests: newRequestTracker()}
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := app.drainMiddleware(func(ctx context.Context, req micro.Request) {
+		close(started)
+		<-finish
+		require.NoError(t, req.Respond([]byte(`{"ok": true}`)))
+	})
+
+	first, firstW := newTestRequest("stock.add")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(t.Context(), first)
+	}()
+	<-started
+	idle := app.requests.drain()
+
+	// New requests are turned away while the request in flight finishes
+	second, w := newTestRequest("stock.add")
+	handler(t.Context(), second)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	resp := schemas.ErrorResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, schemas.ErrorCodeUnavailable, resp.ErrorDetail.Code)
+
+	close(finish)
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Fatal("request in flight did not finish")
+	}
+	<-done
+	assert.Equal(t, http.StatusOK, firstW.Code)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+)
+
+var ErrUnknownEndpoint = errors.New("unknown endpoint")
+
+// Endpoint metadata keys, that tell callers which schemas an endpoint's requests and responses conform to,
+// eg: in the output of `nats micro info StockService`
+const (
+	RequestSchemaMetadata  = "request-schema"
+	ResponseSchemaMetadata = "response-schema"
+)
+
+// stockSchema handles the stock.schema endpoint
+func (app *App) stockSchema(ctx context.Context, rs *requestScope, req schemas.StockSchemaRequest) *schemas.StockSchemaResponse {
+	// Describe the endpoints of the version the request arrived on
+	described := rs.DescribeEndpoints(ctx, app.versionRoutes(apiVersionOf(ctx)), req)
+	if rs.HasError() {
+		return nil
+	}
+	return &schemas.StockSchemaResponse{OK: true, Endpoints: described}
+}
+
+// DescribeEndpoints returns the schemas of the requested endpoint, or of every endpoint. The endpoint can be
+// named by its versioned subject, eg: `stock.v1.add`, or without its version, eg: `stock.add`.
+func (rs *requestScope) DescribeEndpoints(ctx context.Context, routes []route, req schemas.StockSchemaRequest) []schemas.EndpointSchemas {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "describe endpoints")
+	defer span.End()
+
+	if rs.HasError() {
+		return nil
+	}
+	var described []schemas.EndpointSchemas
+	for _, r := range routes {
+		if req.Endpoint != nil && *req.Endpoint != r.subject && *req.Endpoint != r.endpointName() {
+			continue
+		}
+		requestSchema, err := utility.BundleSchema(r.endpoint.requestSchema, schemas.Document)
+		if err != nil {
+			rs.AddSystemError(ctx, err)
+			return nil
+		}
+		responseSchema, err := utility.BundleSchema(r.endpoint.responseSchema, schemas.Document)
+		if err != nil {
+			rs.AddSystemError(ctx, err)
+			return nil
+		}
+		described = append(described, schemas.EndpointSchemas{
+			Name:           r.name(),
+			Subject:        r.subject,
+			Version:        r.version,
+			RequestSchema:  requestSchema,
+			ResponseSchema: responseSchema,
+		})
+	}
+	if len(described) == 0 {
+		rs.AddCallerError(ctx, fmt.Errorf("%w: %s", ErrUnknownEndpoint, *req.Endpoint))
+	}
+	return described
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeEndpoints(t *testing.T) {
+	app := &App{}
+	rs := &requestScope{}
+	routes := app.versionRoutes(APIVersion1)
+	described := rs.DescribeEndpoints(t.Context(), routes, schemas.StockSchemaRequest{})
+	require.NoError(t, rs.GetError())
+	require.Len(t, described, len(routes))
+
+	// Every bundled schema compiles on its own, without loading the schemas it references
+	for _, e := range described {
+		for _, schema := range []map[string]any{e.RequestSchema, e.ResponseSchema} {
+			data, err := json.Marshal(schema)
+			require.NoError(t, err)
+			doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+			require.NoError(t, err)
+			compiler := jsonschema.NewCompiler()
+			require.NoError(t, compiler.AddResource("bundle.json", doc), e.Subject)
+			_, err = compiler.Compile("bundle.json")
+			require.NoError(t, err, e.Subject)
+		}
+	}
+
+	rs = &requestScope{}
+	described = rs.DescribeEndpoints(t.Context(), routes, schemas.StockSchemaRequest{Endpoint: utility.Ptr("stock.get")})
+	require.Len(t, described, 1)
+	assert.Equal(t, "v1-get", described[0].Name)
+	assert.Equal(t, "stock.v1.get", described[0].Subject)
+	assert.Equal(t, APIVersion1, described[0].Version)
+	assert.Equal(t, schemas.StockGetRequestSchema, described[0].RequestSchema["$id"])
+	assert.Contains(t, described[0].RequestSchema["$defs"], "product-sku-reference.json")
+	assert.Contains(t, described[0].ResponseSchema["$defs"], "product-sku.json")
+
+	rs = &requestScope{}
+	described = rs.DescribeEndpoints(t.Context(), routes, schemas.StockSchemaRequest{Endpoint: utility.Ptr("stock.v1.get")})
+	require.Len(t, described, 1)
+
+	rs = &requestScope{}
+	rs.DescribeEndpoints(t.Context(), routes, schemas.StockSchemaRequest{Endpoint: utility.Ptr("stock.missing")})
+	require.ErrorIs(t, rs.GetError(), ErrUnknownEndpoint)
+	assert.Equal(t, schemas.ErrorCodeNotFound, errorCodeOf(rs.GetError(), false))
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Versions of the API. Each version is served under its own subjects, eg: `stock.v1.add`, so a breaking
+// change to an endpoint's schemas is made in a new version, and callers move across when they are ready.
+const (
+	APIVersion1 = "v1"
+)
+
+// legacyVersion is also served on the unversioned subjects, eg: `stock.add`, that callers used before
+// the API was versioned
+const legacyVersion = APIVersion1
+
+// APIVersionMetadata is the endpoint metadata key that holds the endpoint's API version
+const APIVersionMetadata = "api-version"
+
+// apiVersion is a version of the API, and the endpoints it serves
+type apiVersion struct {
+	name      string
+	endpoints []endpoint
+}
+
+// versions returns every version of the API, oldest first. A new version is only added once an endpoint
+// needs a breaking change. It starts with the endpoints of the version before, and replaces the endpoint
+// that changes with one that has new schemas and a new handler, leaving the old version unchanged.
+func (app *App) versions() []apiVersion {
+	return []apiVersion{
+		{name: APIVersion1, endpoints: app.endpoints()},
+	}
+}
+
+// route is an endpoint served on a subject
+type route struct {
+	endpoint endpoint
+	// subject is the full subject, eg: `stock.v1.add`
+	subject string
+	version string
+	// legacy is true for the unversioned subjects
+	legacy bool
+}
+
+// routes returns every subject the endpoints are served on, the unversioned subjects of the legacy version
+// first, followed by the subjects of each version
+func (app *App) routes() []route {
+	var routes []route
+	versions := app.versions()
+	for _, v := range versions {
+		if v.name == legacyVersion {
+			for _, e := range v.endpoints {
+				routes = append(routes, route{endpoint: e, subject: stockGroup + "." + e.subject, version: v.name, legacy: true})
+			}
+		}
+	}
+	for _, v := range versions {
+		routes = append(routes, v.routes()...)
+	}
+	return routes
+}
+
+// routes returns the versioned subjects of the version's endpoints
+func (v apiVersion) routes() []route {
+	routes := make([]route, 0, len(v.endpoints))
+	for _, e := range v.endpoints {
+		routes = append(routes, route{endpoint: e, subject: stockGroup + "." + v.name + "." + e.subject, version: v.name})
+	}
+	return routes
+}
+
+// versionRoutes returns the versioned subjects of a version, or nil if there is no such version
+func (app *App) versionRoutes(version string) []route {
+	for _, v := range app.versions() {
+		if v.name == version {
+			return v.routes()
+		}
+	}
+	return nil
+}
+
+// name identifies the route in the service info and stats
+func (r route) name() string {
+	if r.legacy {
+		return r.endpoint.name
+	}
+	return r.version + "-" + r.endpoint.name
+}
+
+// endpointName names the endpoint without its version, eg: `stock.add`. Authorization policies, rate limits
+// and concurrency caps name endpoints this way, so they apply to every version of an endpoint.
+func (r route) endpointName() string {
+	return stockGroup + "." + r.endpoint.subject
+}
+
+// metadata returns the micro endpoint metadata that describes the route
+func (r route) metadata() map[string]string {
+	return map[string]string{
+		RequestSchemaMetadata:  r.endpoint.requestSchema,
+		ResponseSchemaMetadata: r.endpoint.responseSchema,
+		APIVersionMetadata:     r.version,
+	}
+}
+
+type routeKey struct{}
+
+// withRoute returns a copy of ctx that carries the route the request arrived on
+func withRoute(ctx context.Context, r route) context.Context {
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+// routeFrom returns the route stored in ctx
+func routeFrom(ctx context.Context) (route, bool) {
+	r, ok := ctx.Value(routeKey{}).(route)
+	return r, ok
+}
+
+// endpointName names the endpoint the request is for without its version, falling back to the request's
+// subject when the route isn't known
+func endpointName(ctx context.Context, req micro.Request) string {
+	if r, ok := routeFrom(ctx); ok {
+		return r.endpointName()
+	}
+	return req.Subject()
+}
+
+// isLegacyRoute reports whether the request arrived on an unversioned subject
+func isLegacyRoute(ctx context.Context) bool {
+	r, ok := routeFrom(ctx)
+	return !ok || r.legacy
+}
+
+// apiVersionOf returns the API version of the request, or the legacy version when the route isn't known
+func apiVersionOf(ctx context.Context) string {
+	if r, ok := routeFrom(ctx); ok {
+		return r.version
+	}
+	return legacyVersion
+}
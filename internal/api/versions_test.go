@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes(t *testing.T) {
+	app := &App{}
+	subjects := map[string]route{}
+	names := map[string]bool{}
+	for _, r := range app.routes() {
+		require.NotContains(t, subjects, r.subject, "each subject is served once")
+		require.NotContains(t, names, r.name(), "each route has its own name")
+		subjects[r.subject] = r
+		names[r.name()] = true
+	}
+
+	// The legacy subjects are served by the v1 endpoints
+	legacy := subjects["stock.add"]
+	assert.True(t, legacy.legacy)
+	assert.Equal(t, APIVersion1, legacy.version)
+	assert.Equal(t, "add", legacy.name())
+
+	r := subjects["stock.v1.add"]
+	assert.False(t, r.legacy)
+	assert.Equal(t, "stock.add", r.endpointName())
+	assert.Equal(t, APIVersion1, r.metadata()[APIVersionMetadata])
+	assert.Equal(t, r.endpoint.requestSchema, r.metadata()[RequestSchemaMetadata])
+	assert.Equal(t, r.endpoint.responseSchema, r.metadata()[ResponseSchemaMetadata])
+	assert.Equal(t, "v1-add", r.name())
+
+	// A version is only served once its endpoints differ from the version before
+	assert.NotContains(t, subjects, "stock.v2.add")
+	assert.Len(t, app.versionRoutes(APIVersion1), len(app.endpoints()))
+	assert.Nil(t, app.versionRoutes("v2"))
+}
+
+func TestRouteContext(t *testing.T) {
+	req, _ := newTestRequest("stock.v1.add")
+
+	// Without a route the subject names the endpoint
+	assert.Equal(t, "stock.v1.add", endpointName(t.Context(), req))
+	assert.Equal(t, legacyVersion, apiVersionOf(t.Context()))
+
+	var got context.Context
+	app := &App{}
+	r := app.versionRoutes(APIVersion1)[0]
+	r.endpoint.handler = func(ctx context.Context, req micro.Request) { got = ctx }
+	r.endpoint.middleware = nil
+	app.metrics, _ = newAppMetrics()
+	app.requests = newRequestTracker()
+	app.routeHandler(r)(t.Context(), req)
+	require.NotNil(t, got)
+	assert.Equal(t, "stock.add", endpointName(got, req))
+	assert.Equal(t, APIVersion1, apiVersionOf(got))
+	assert.False(t, isLegacyRoute(got))
+}
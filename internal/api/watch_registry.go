@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultWatchLease is used when a caller does not ask for a specific lease
+	DefaultWatchLease = 60 * time.Second
+
+	// watchExpiryInterval is how often expired watches are cleaned up
+	watchExpiryInterval = time.Second
+
+	// DefaultWatchInboxPrefix is the prefix of the inboxes that NATS clients create with NewInbox
+	DefaultWatchInboxPrefix = "_INBOX"
+
+	// eventSubjectRoot starts the subject of every event the service publishes
+	eventSubjectRoot = "events."
+
+	// watchOwnerSubjectRoot starts the subjects that an instance is sent renew and cancel requests on, for
+	// the watches it owns. They are only used between instances, and are not exported to callers.
+	watchOwnerSubjectRoot = "_BEAKER.watch."
+
+	// watchForwardTimeout caps how long to wait for another instance to renew or cancel its watch, when the
+	// request has no earlier deadline
+	watchForwardTimeout = 5 * time.Second
+)
+
+var (
+	ErrWatchNotFound = errors.New("watch not found or lease expired")
+	ErrInvalidInbox  = errors.New("invalid watch inbox")
+)
+
+// stockWatch is a single caller's interest in the stock levels of a set of products
+type stockWatch struct {
+	id        string
+	tenant    string
+	inbox     string
+	skus      []string
+	expiresAt time.Time
+}
+
+// watchRegistry tracks the active stock watches, publishes stock level changes to the watch inboxes,
+// and removes watches whose lease has run out.
+// Changes are picked up from the stock changed events, so a watch sees changes made by every instance
+// of the service, not just the one that it was registered with.
+// A watch is kept by the instance that registered it, and its ID starts with that instance's ID. Renew
+// and cancel requests can reach any instance, so those for another instance's watch are forwarded to it.
+type watchRegistry struct {
+	nc *nats.Conn
+	// instance identifies this registry's watches among those of every instance of the service
+	instance string
+	sub      *nats.Subscription
+	ownerSub *nats.Subscription
+	// inboxPrefix is the prefix every watch inbox must start with
+	inboxPrefix string
+
+	mu      sync.Mutex
+	watches map[string]*stockWatch
+	// bySKU indexes the watches by tenant and product, see watchKey
+	bySKU map[string]map[string]*stockWatch
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newWatchRegistry returns a registry whose watches publish to inboxes under inboxPrefix, or under
+// DefaultWatchInboxPrefix when it is empty
+func newWatchRegistry(nc *nats.Conn, inboxPrefix string) *watchRegistry {
+	if inboxPrefix == "" {
+		inboxPrefix = DefaultWatchInboxPrefix
+	}
+	return &watchRegistry{
+		nc:          nc,
+		instance:    uuid.NewString(),
+		inboxPrefix: inboxPrefix,
+		watches:     map[string]*stockWatch{},
+		bySKU:       map[string]map[string]*stockWatch{},
+	}
+}
+
+// Start subscribes to the stock changed events and to the requests forwarded by other instances, and runs
+// a background goroutine that removes expired watches until Stop is called
+func (wr *watchRegistry) Start() error {
+	sub, err := wr.nc.Subscribe(schemas.StockChangedSubjectPrefix+">", wr.handleStockChanged)
+	if err != nil {
+		return err
+	}
+	ownerSub, err := wr.nc.Subscribe(watchOwnerSubject(wr.instance, "*"), wr.handleOwnerRequest)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return err
+	}
+	wr.sub = sub
+	wr.ownerSub = ownerSub
+	wr.stop = make(chan struct{})
+	wr.done = make(chan struct{})
+	go func() {
+		defer close(wr.done)
+		ticker := time.NewTicker(watchExpiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-wr.stop:
+				return
+			case now := <-ticker.C:
+				wr.expire(now)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the background cleanup, and drops all the watches
+func (wr *watchRegistry) Stop() {
+	if wr.stop == nil {
+		return
+	}
+	_ = wr.sub.Unsubscribe()
+	_ = wr.ownerSub.Unsubscribe()
+	close(wr.stop)
+	<-wr.done
+	wr.stop = nil
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.watches = map[string]*stockWatch{}
+	wr.bySKU = map[string]map[string]*stockWatch{}
+}
+
+// watchKey is the bySKU index key. Watches only see changes to their own tenant's products.
+func watchKey(tenant, sku string) string {
+	return tenant + "." + sku
+}
+
+// Add registers a new watch and returns a copy of it. The inbox must be under the registry's inbox
+// prefix, see checkInbox.
+func (wr *watchRegistry) Add(tenant, inbox string, skus []string, lease time.Duration) (stockWatch, error) {
+	if err := wr.checkInbox(inbox); err != nil {
+		return stockWatch{}, err
+	}
+	watch := &stockWatch{
+		id:        wr.instance + "." + uuid.NewString(),
+		tenant:    tenant,
+		inbox:     inbox,
+		skus:      skus,
+		expiresAt: time.Now().Add(lease),
+	}
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.watches[watch.id] = watch
+	for _, sku := range skus {
+		key := watchKey(tenant, sku)
+		if wr.bySKU[key] == nil {
+			wr.bySKU[key] = map[string]*stockWatch{}
+		}
+		wr.bySKU[key][watch.id] = watch
+	}
+	return *watch, nil
+}
+
+// checkInbox rejects an inbox that isn't under the inbox prefix. Updates are published with the service's
+// own credentials, so a caller must not be able to point a watch at any other subject, least of all the
+// event subjects that the service and its consumers trust.
+func (wr *watchRegistry) checkInbox(inbox string) error {
+	if !strings.HasPrefix(inbox, wr.inboxPrefix+".") || strings.HasPrefix(inbox, eventSubjectRoot) {
+		return fmt.Errorf("%w: %s must be a subject under %s.", ErrInvalidInbox, inbox, wr.inboxPrefix)
+	}
+	return nil
+}
+
+// Renew extends the lease of a watch, returning the new expiry time.
+// A tenant can only renew its own watches.
+func (wr *watchRegistry) Renew(ctx context.Context, tenant, id string, lease time.Duration) (time.Time, error) {
+	if owner, local := wr.owner(id); !local {
+		reply, err := wr.forward(ctx, owner, "renew", watchOwnerRequest{Tenant: tenant, WatchID: id, Lease: lease})
+		return reply.ExpiresAt, err
+	}
+	return wr.renew(tenant, id, lease)
+}
+
+// Cancel removes a watch. A tenant can only cancel its own watches.
+func (wr *watchRegistry) Cancel(ctx context.Context, tenant, id string) error {
+	if owner, local := wr.owner(id); !local {
+		_, err := wr.forward(ctx, owner, "cancel", watchOwnerRequest{Tenant: tenant, WatchID: id})
+		return err
+	}
+	return wr.cancel(tenant, id)
+}
+
+func (wr *watchRegistry) renew(tenant, id string, lease time.Duration) (time.Time, error) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	watch, ok := wr.watches[id]
+	if !ok || watch.tenant != tenant || time.Now().After(watch.expiresAt) {
+		return time.Time{}, ErrWatchNotFound
+	}
+	watch.expiresAt = time.Now().Add(lease)
+	return watch.expiresAt, nil
+}
+
+func (wr *watchRegistry) cancel(tenant, id string) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	watch, ok := wr.watches[id]
+	if !ok || watch.tenant != tenant {
+		return ErrWatchNotFound
+	}
+	wr.remove(watch)
+	return nil
+}
+
+// owner returns the instance that a watch ID was issued by, and whether that is this instance.
+// An ID that no instance could have issued is treated as local, so it is simply not found.
+func (wr *watchRegistry) owner(id string) (string, bool) {
+	owner, _, ok := strings.Cut(id, ".")
+	if !ok || owner == wr.instance || uuid.Validate(owner) != nil {
+		return wr.instance, true
+	}
+	return owner, false
+}
+
+// watchOwnerSubject is the subject that an instance receives an action on its watches on
+func watchOwnerSubject(instance, action string) string {
+	return watchOwnerSubjectRoot + instance + "." + action
+}
+
+// watchOwnerRequest asks the instance that owns a watch to renew or cancel it
+type watchOwnerRequest struct {
+	Tenant  string        `json:"tenant"`
+	WatchID string        `json:"watch-id"`
+	Lease   time.Duration `json:"lease,omitempty"`
+}
+
+// watchOwnerReply is the owning instance's answer to a watchOwnerRequest
+type watchOwnerReply struct {
+	ExpiresAt time.Time `json:"expires-at,omitzero"`
+	NotFound  bool      `json:"not-found,omitempty"`
+}
+
+// forward sends an action on a watch to the instance that owns it. If that instance has gone, so have its
+// watches, and the watch is not found.
+func (wr *watchRegistry) forward(ctx context.Context, owner, action string, req watchOwnerRequest) (watchOwnerReply, error) {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "forward watch "+action)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, watchForwardTimeout)
+	defer cancel()
+	reply := watchOwnerReply{}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return reply, err
+	}
+	msg := nats.NewMsg(watchOwnerSubject(owner, action))
+	msg.Data = data
+	telemetry.InjectContext(ctx, msg.Header)
+	resp, err := wr.nc.RequestMsgWithContext(ctx, msg)
+	if errors.Is(err, nats.ErrNoResponders) {
+		return reply, ErrWatchNotFound
+	}
+	if err != nil {
+		return reply, fmt.Errorf("forwarding watch %s to instance %s: %w", action, owner, err)
+	}
+	if err := json.Unmarshal(resp.Data, &reply); err != nil {
+		return reply, fmt.Errorf("reading watch %s reply from instance %s: %w", action, owner, err)
+	}
+	if reply.NotFound {
+		return reply, ErrWatchNotFound
+	}
+	return reply, nil
+}
+
+// handleOwnerRequest renews or cancels one of this instance's watches, for the instance that received the
+// caller's request
+func (wr *watchRegistry) handleOwnerRequest(msg *nats.Msg) {
+	ctx := telemetry.ExtractContext(context.Background(), msg.Header)
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "process "+msg.Subject, trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	req := watchOwnerRequest{}
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		slog.ErrorContext(ctx, "Failed to unmarshal watch owner request", "error", err, "subject", msg.Subject)
+		return
+	}
+	reply := watchOwnerReply{}
+	var err error
+	switch strings.TrimPrefix(msg.Subject, watchOwnerSubjectRoot+wr.instance+".") {
+	case "renew":
+		reply.ExpiresAt, err = wr.renew(req.Tenant, req.WatchID, req.Lease)
+	case "cancel":
+		err = wr.cancel(req.Tenant, req.WatchID)
+	default:
+		slog.ErrorContext(ctx, "Unexpected watch owner subject", "subject", msg.Subject)
+		return
+	}
+	reply.NotFound = errors.Is(err, ErrWatchNotFound)
+	data, err := json.Marshal(reply)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to marshal watch owner reply", "error", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		slog.ErrorContext(ctx, "Failed to reply to watch owner request", "error", err, "subject", msg.Subject)
+	}
+}
+
+// handleStockChanged notifies the watches on the product named in a StockChangedEvent. Every event the
+// service publishes carries a CloudEvents type, so a message without one didn't come from the service
+// and is ignored.
+func (wr *watchRegistry) handleStockChanged(msg *nats.Msg) {
+	if msg.Header.Get(CloudEventTypeHeader) == "" {
+		slog.Warn("Ignoring stock changed message without a CloudEvents type", "subject", msg.Subject)
+		return
+	}
+	// The tenant is only carried in the subject
+	tenant, _, ok := schemas.ParseStockChangedSubject(msg.Subject)
+	if !ok {
+		slog.Error("Unexpected stock changed event subject", "subject", msg.Subject)
+		return
+	}
+	event := schemas.StockChangedEvent{}
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		slog.Error("Failed to unmarshal stock changed event", "error", err, "subject", msg.Subject)
+		return
+	}
+	// Continue the trace of the request that changed the stock
+	ctx := telemetry.ExtractContext(context.Background(), msg.Header)
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "process "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(telemetry.MessagingAttributes(semconv.MessagingOperationTypeProcess, msg.Subject, len(msg.Data))...))
+	defer span.End()
+
+	wr.Notify(ctx, tenant, event.ProductSKU, event.NewLevel)
+}
+
+// Notify publishes a stock level change to the inbox of every watch on the tenant's product
+func (wr *watchRegistry) Notify(ctx context.Context, tenant, sku string, quantity int) {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "notify watches")
+	defer span.End()
+
+	// Copy the targets so we don't hold the lock while publishing
+	wr.mu.Lock()
+	now := time.Now()
+	var targets []stockWatch
+	for _, watch := range wr.bySKU[watchKey(tenant, sku)] {
+		if now.Before(watch.expiresAt) {
+			targets = append(targets, *watch)
+		}
+	}
+	wr.mu.Unlock()
+
+	for _, watch := range targets {
+		update := schemas.StockWatchUpdate{
+			WatchID:    watch.id,
+			ProductSKU: sku,
+			Quantity:   quantity,
+		}
+		data, err := json.Marshal(update)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to marshal stock watch update", "error", err)
+			continue
+		}
+		msg := nats.NewMsg(watch.inbox)
+		msg.Data = data
+		telemetry.InjectContext(ctx, msg.Header)
+		if err := wr.nc.PublishMsg(msg); err != nil {
+			slog.ErrorContext(ctx, "Failed to publish stock watch update", "error", err, "watch_id", watch.id)
+		}
+	}
+}
+
+// expire removes every watch whose lease ran out before now
+func (wr *watchRegistry) expire(now time.Time) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	for _, watch := range wr.watches {
+		if now.After(watch.expiresAt) {
+			slog.Info("stock watch lease expired", "watch_id", watch.id)
+			wr.remove(watch)
+		}
+	}
+}
+
+// remove deletes a watch from the indexes, the caller must hold the lock
+func (wr *watchRegistry) remove(watch *stockWatch) {
+	delete(wr.watches, watch.id)
+	for _, sku := range watch.skus {
+		key := watchKey(watch.tenant, sku)
+		delete(wr.bySKU[key], watch.id)
+		if len(wr.bySKU[key]) == 0 {
+			delete(wr.bySKU, key)
+		}
+	}
+}
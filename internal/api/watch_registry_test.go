@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/davidoram/beaker/schemas"
+	"github.com/google/uuid"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchRegistryInbox(t *testing.T) {
+	wr := newWatchRegistry(nil, "")
+	_, err := wr.Add("tenant-a", "_INBOX.abc123", []string{"tea"}, time.Minute)
+	require.NoError(t, err)
+
+	for _, inbox := range []string{
+		"events.stock.changed.tenant-a.tea",
+		"events.low_stock.tenant-a",
+		"stock.add",
+		"_INBOX",
+		"_INBOXES.abc123",
+	} {
+		_, err := wr.Add("tenant-a", inbox, []string{"tea"}, time.Minute)
+		assert.ErrorIs(t, err, ErrInvalidInbox, inbox)
+	}
+
+	// A configured prefix replaces the default one, and can't be used to reach the event subjects
+	wr = newWatchRegistry(nil, "_INBOX_beaker")
+	_, err = wr.Add("tenant-a", "_INBOX_beaker.abc123", []string{"tea"}, time.Minute)
+	require.NoError(t, err)
+	_, err = wr.Add("tenant-a", "_INBOX.abc123", []string{"tea"}, time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidInbox)
+	wr = newWatchRegistry(nil, "events")
+	_, err = wr.Add("tenant-a", "events.stock.changed.tenant-a.tea", []string{"tea"}, time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidInbox)
+}
+
+func TestWatchRegistryIgnoresMessagesWithoutType(t *testing.T) {
+	ns := natsserver.RunRandClientPortServer()
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	wr := newWatchRegistry(nc, "")
+	require.NoError(t, wr.Start())
+	defer wr.Stop()
+	inbox := nc.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	require.NoError(t, err)
+	watch, err := wr.Add("tenant-a", inbox, []string{"tea"}, time.Minute)
+	require.NoError(t, err)
+
+	event := schemas.StockChangedEvent{TenantID: "tenant-a", ProductSKU: "tea", Operation: schemas.StockOperationAdd, Delta: 1, NewLevel: 1}
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	// A message that the service didn't publish is ignored
+	require.NoError(t, nc.Publish(event.Subject(), data))
+	require.NoError(t, nc.Flush())
+	_, err = sub.NextMsg(200 * time.Millisecond)
+	require.ErrorIs(t, err, nats.ErrTimeout)
+
+	msg := nats.NewMsg(event.Subject())
+	msg.Header.Set(CloudEventTypeHeader, event.Type())
+	msg.Data = data
+	require.NoError(t, nc.PublishMsg(msg))
+	update, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	var watchUpdate schemas.StockWatchUpdate
+	require.NoError(t, json.Unmarshal(update.Data, &watchUpdate))
+	assert.Equal(t, schemas.StockWatchUpdate{WatchID: watch.id, ProductSKU: "tea", Quantity: 1}, watchUpdate)
+}
+
+func TestWatchRegistryForwardsToOwner(t *testing.T) {
+	ns := natsserver.RunRandClientPortServer()
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	owner := newWatchRegistry(nc, "")
+	require.NoError(t, owner.Start())
+	defer owner.Stop()
+	other := newWatchRegistry(nc, "")
+	require.NoError(t, other.Start())
+	defer other.Stop()
+
+	watch, err := owner.Add("tenant-a", nc.NewInbox(), []string{"tea"}, time.Minute)
+	require.NoError(t, err)
+
+	// Another instance renews the watch with its owner
+	expiresAt, err := other.Renew(t.Context(), "tenant-a", watch.id, 2*time.Minute)
+	require.NoError(t, err)
+	assert.True(t, expiresAt.After(watch.expiresAt))
+
+	// Only the watch's own tenant can renew or cancel it
+	_, err = other.Renew(t.Context(), "tenant-b", watch.id, time.Minute)
+	assert.ErrorIs(t, err, ErrWatchNotFound)
+	assert.ErrorIs(t, other.Cancel(t.Context(), "tenant-b", watch.id), ErrWatchNotFound)
+
+	require.NoError(t, other.Cancel(t.Context(), "tenant-a", watch.id))
+	_, err = owner.Renew(t.Context(), "tenant-a", watch.id, time.Minute)
+	assert.ErrorIs(t, err, ErrWatchNotFound)
+
+	// The watches of an instance that has gone went with it
+	watch, err = owner.Add("tenant-a", nc.NewInbox(), []string{"tea"}, time.Minute)
+	require.NoError(t, err)
+	owner.Stop()
+	_, err = other.Renew(t.Context(), "tenant-a", watch.id, time.Minute)
+	assert.ErrorIs(t, err, ErrWatchNotFound)
+
+	// IDs that no instance issued are not found
+	for _, id := range []string{"", "abc", uuid.NewString(), "*.abc", ">"} {
+		assert.ErrorIs(t, other.Cancel(t.Context(), "tenant-a", id), ErrWatchNotFound, id)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/davidoram/beaker/internal/db"
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/jackc/pgx/v5"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// stockWatchHandler takes a stock.watch request through the same steps as a readOnlyTx endpointDef, but
+// cancels the watch it started if the request fails, including when the transaction fails to end
+func (app *App) stockWatchHandler(ctx context.Context, req micro.Request) {
+	rs := newRequestScope(ctx, req, app.nc, app.db, pgx.ReadOnly)
+	defer rs.Close(ctx)
+	rs.ValidateJSON(ctx, app.schemas, req.Data(), schemas.StockWatchRequestSchema)
+	watchReq := DecodeRequest[schemas.StockWatchRequest](ctx, rs)
+	var watch *stockWatch
+	var resp *schemas.StockWatchResponse
+	if !rs.HasError() {
+		watch, resp = app.stockWatch(ctx, rs, watchReq)
+	}
+	if resp == nil {
+		resp = &schemas.StockWatchResponse{}
+	}
+	rs.CommitOrRollback(ctx)
+	if rs.HasError() && watch != nil {
+		_ = app.watches.Cancel(ctx, watch.tenant, watch.id)
+	}
+	rs.RespondJSON(ctx, req, app.responses, resp)
+}
+
+// stockWatch handles the stock.watch endpoint, returning the watch it started along with the response
+func (app *App) stockWatch(ctx context.Context, rs *requestScope, req schemas.StockWatchRequest) (*stockWatch, *schemas.StockWatchResponse) {
+	req.ProductSKUs = app.resolveSKUs(ctx, rs, req.ProductSKUs)
+	// Start watching before reading the snapshot, so no change can slip between the two
+	watch := rs.StartWatch(ctx, app.watches, req)
+	levels := rs.GetStockLevels(ctx, req.ProductSKUs)
+	if rs.HasError() {
+		return watch, nil
+	}
+	resp := &schemas.StockWatchResponse{
+		OK:             true,
+		WatchID:        utility.Ptr(watch.id),
+		LeaseExpiresAt: utility.Ptr(watch.expiresAt),
+		StockLevels:    make([]schemas.StockLevel, len(levels)),
+	}
+	for i, inventory := range levels {
+		resp.StockLevels[i] = schemas.StockLevel{
+			ProductSKU: inventory.ProductSku,
+			Quantity:   int(inventory.StockLevel),
+		}
+	}
+	return watch, resp
+}
+
+// stockWatchRenew handles the stock.watch.renew endpoint
+func (app *App) stockWatchRenew(ctx context.Context, rs *requestScope, req schemas.StockWatchRenewRequest) *schemas.StockWatchRenewResponse {
+	expiresAt := rs.RenewWatch(ctx, app.watches, req)
+	if rs.HasError() {
+		return nil
+	}
+	return &schemas.StockWatchRenewResponse{
+		OK:             true,
+		WatchID:        utility.Ptr(req.WatchID),
+		LeaseExpiresAt: utility.Ptr(expiresAt),
+	}
+}
+
+// stockWatchCancel handles the stock.watch.cancel endpoint
+func (app *App) stockWatchCancel(ctx context.Context, rs *requestScope, req schemas.StockWatchCancelRequest) *schemas.StockWatchCancelResponse {
+	rs.CancelWatch(ctx, app.watches, req)
+	if rs.HasError() {
+		return nil
+	}
+	return &schemas.StockWatchCancelResponse{OK: true, WatchID: utility.Ptr(req.WatchID)}
+}
+
+// StartWatch registers a watch for the requested products
+func (rs *requestScope) StartWatch(ctx context.Context, watches *watchRegistry, req schemas.StockWatchRequest) *stockWatch {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "start watch")
+	defer span.End()
+
+	if rs.HasError() {
+		return nil
+	}
+	watch, err := watches.Add(rs.tenant, req.Inbox, req.ProductSKUs, leaseDuration(req.LeaseSeconds))
+	if err != nil {
+		rs.AddCallerError(ctx, err)
+		return nil
+	}
+	return &watch
+}
+
+// GetStockLevels retrieves the stock levels for a set of products, in the order they were requested.
+// Products with no inventory have a stock level of 0.
+func (rs *requestScope) GetStockLevels(ctx context.Context, skus []string) []db.Inventory {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "get stock levels")
+	defer span.End()
+
+	if rs.HasError() {
+		return nil
+	}
+
+	inventories, err := rs.queries.GetInventories(ctx, db.GetInventoriesParams{TenantID: rs.tenant, ProductSkus: skus})
+	if err != nil {
+		rs.AddSystemError(ctx, err)
+		return nil
+	}
+	found := make(map[string]db.Inventory, len(inventories))
+	for _, inventory := range inventories {
+		found[inventory.ProductSku] = inventory
+	}
+	levels := make([]db.Inventory, len(skus))
+	for i, sku := range skus {
+		if inventory, ok := found[sku]; ok {
+			levels[i] = inventory
+		} else {
+			levels[i] = db.Inventory{TenantID: rs.tenant, ProductSku: sku, StockLevel: 0}
+		}
+	}
+	return levels
+}
+
+// RenewWatch extends the lease on a watch
+func (rs *requestScope) RenewWatch(ctx context.Context, watches *watchRegistry, req schemas.StockWatchRenewRequest) time.Time {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "renew watch")
+	defer span.End()
+
+	if rs.HasError() {
+		return time.Time{}
+	}
+	expiresAt, err := watches.Renew(ctx, rs.tenant, req.WatchID, leaseDuration(req.LeaseSeconds))
+	rs.addWatchError(ctx, err)
+	return expiresAt
+}
+
+// CancelWatch stops a watch
+func (rs *requestScope) CancelWatch(ctx context.Context, watches *watchRegistry, req schemas.StockWatchCancelRequest) {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "cancel watch")
+	defer span.End()
+
+	if rs.HasError() {
+		return
+	}
+	rs.addWatchError(ctx, watches.Cancel(ctx, rs.tenant, req.WatchID))
+}
+
+// addWatchError records the error from renewing or cancelling a watch. A watch that isn't found is the
+// caller's mistake, but failing to reach the instance that owns it is ours.
+func (rs *requestScope) addWatchError(ctx context.Context, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrWatchNotFound):
+		rs.AddCallerError(ctx, err)
+	default:
+		rs.AddSystemError(ctx, err)
+	}
+}
+
+func leaseDuration(leaseSeconds *int) time.Duration {
+	if leaseSeconds == nil {
+		return DefaultWatchLease
+	}
+	return time.Duration(*leaseSeconds) * time.Second
+}
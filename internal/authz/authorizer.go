@@ -0,0 +1,85 @@
+package authz
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReloadInterval is how often a policy file is checked for changes
+const DefaultReloadInterval = 5 * time.Second
+
+// Authorizer applies the policy held in a file. The file is reloaded when it changes, so the
+// policy can be updated without restarting the service.
+type Authorizer struct {
+	path   string
+	policy atomic.Pointer[Policy]
+
+	// modTime is the modification time of the file when the policy was last loaded
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewFileAuthorizer loads the policy held in the file at path
+func NewFileAuthorizer(path string) (*Authorizer, error) {
+	a := &Authorizer{path: path}
+	if _, err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authorize checks the request against the current policy, see Policy.Authorize
+func (a *Authorizer) Authorize(principal Principal, endpoint string, skus []string) error {
+	return a.policy.Load().Authorize(principal, endpoint, skus)
+}
+
+// Reload reads the policy file again if it has changed since it was last loaded, and reports whether
+// the policy was replaced. If the new file is invalid the current policy is kept, and the error returned.
+func (a *Authorizer) Reload() (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return false, err
+	}
+	if a.policy.Load() != nil && info.ModTime().Equal(a.modTime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return false, err
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return false, err
+	}
+	a.policy.Store(policy)
+	a.modTime = info.ModTime()
+	return true, nil
+}
+
+// Watch checks the policy file for changes every interval, until the context is cancelled
+func (a *Authorizer) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloaded, err := a.Reload()
+				if err != nil {
+					slog.ErrorContext(ctx, "Unable to reload authorization policy, keeping the current policy", "path", a.path, "error", err)
+				} else if reloaded {
+					slog.InfoContext(ctx, "Reloaded authorization policy", "path", a.path)
+				}
+			}
+		}
+	}()
+}
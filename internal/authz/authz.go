@@ -0,0 +1,150 @@
+// Package authz decides which endpoints, and which products, a caller is allowed to use.
+//
+// A policy is a JSON document with named roles, and bindings that grant roles to callers:
+//
+//	{
+//	  "roles": {
+//	    "reader": {"endpoints": ["stock.get", "stock.watch", "stock.watch.*"]},
+//	    "writer": {"endpoints": ["stock.*"], "skus": ["coffee-*"]}
+//	  },
+//	  "bindings": [
+//	    {"account": "ACCOUNT-ID", "roles": ["reader"]},
+//	    {"tenant": "acme", "user": "USER-ID", "roles": ["reader", "writer"]}
+//	  ]
+//	}
+//
+// Endpoint and SKU patterns use the syntax of path.Match, so `*` matches any run of characters.
+// A role without any `skus` may be used with every product.
+package authz
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+)
+
+var (
+	ErrForbidden = errors.New("forbidden")
+	ErrBadPolicy = errors.New("invalid authorization policy")
+)
+
+// Principal identifies the caller of an endpoint
+type Principal struct {
+	Account string
+	User    string
+	Tenant  string
+}
+
+// Role is a named set of permissions
+type Role struct {
+	// Endpoints are patterns matching the endpoint subjects the role may call
+	Endpoints []string `json:"endpoints"`
+	// SKUs are patterns matching the products the role may use, empty means any product
+	SKUs []string `json:"skus,omitempty"`
+}
+
+// Binding grants roles to every caller that matches it. Empty fields match any caller.
+type Binding struct {
+	Account string   `json:"account,omitempty"`
+	User    string   `json:"user,omitempty"`
+	Tenant  string   `json:"tenant,omitempty"`
+	Roles   []string `json:"roles"`
+}
+
+// Policy is a parsed authorization policy
+type Policy struct {
+	Roles    map[string]Role `json:"roles"`
+	Bindings []Binding       `json:"bindings"`
+}
+
+// ParsePolicy parses and checks a JSON policy document
+func ParsePolicy(data []byte) (*Policy, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	policy := &Policy{}
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPolicy, err)
+	}
+	if err := policy.check(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadPolicy, err)
+	}
+	return policy, nil
+}
+
+// check makes sure every pattern is well formed, and every binding refers to a known role
+func (p *Policy) check() error {
+	for name, role := range p.Roles {
+		for _, pattern := range slices.Concat(role.Endpoints, role.SKUs) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("role %s has bad pattern %q: %w", name, pattern, err)
+			}
+		}
+	}
+	for i, binding := range p.Bindings {
+		if len(binding.Roles) == 0 {
+			return fmt.Errorf("binding %d has no roles", i)
+		}
+		for _, name := range binding.Roles {
+			if _, ok := p.Roles[name]; !ok {
+				return fmt.Errorf("binding %d refers to unknown role %s", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Authorize returns nil if the principal may call the endpoint for every one of the skus. Each SKU may
+// be allowed by a different one of the principal's roles that grant the endpoint.
+// Otherwise it returns an error wrapping ErrForbidden.
+func (p *Policy) Authorize(principal Principal, endpoint string, skus []string) error {
+	roles := p.rolesFor(principal, endpoint)
+	if len(roles) == 0 {
+		return fmt.Errorf("%w: caller may not use %s", ErrForbidden, endpoint)
+	}
+	for _, sku := range skus {
+		if !slices.ContainsFunc(roles, func(r Role) bool { return r.allowsSKU(sku) }) {
+			return fmt.Errorf("%w: caller may not use %s for %s", ErrForbidden, endpoint, sku)
+		}
+	}
+	return nil
+}
+
+// rolesFor returns the roles bound to the principal that grant the endpoint
+func (p *Policy) rolesFor(principal Principal, endpoint string) []Role {
+	var roles []Role
+	for _, binding := range p.Bindings {
+		if !binding.matches(principal) {
+			continue
+		}
+		for _, name := range binding.Roles {
+			if role := p.Roles[name]; matchesAny(role.Endpoints, endpoint) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+func (b Binding) matches(principal Principal) bool {
+	return (b.Account == "" || b.Account == principal.Account) &&
+		(b.User == "" || b.User == principal.User) &&
+		(b.Tenant == "" || b.Tenant == principal.Tenant)
+}
+
+func (r Role) allowsSKU(sku string) bool {
+	return len(r.SKUs) == 0 || matchesAny(r.SKUs, sku)
+}
+
+// matchesAny reports whether any of the patterns match s. Patterns have been checked by ParsePolicy,
+// so matching cannot fail.
+func matchesAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
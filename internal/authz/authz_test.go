@@ -0,0 +1,110 @@
+package authz
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPolicy = `{
+  "roles": {
+    "reader": {"endpoints": ["stock.get", "stock.watch", "stock.watch.*"]},
+    "writer": {"endpoints": ["stock.*"], "skus": ["coffee-*"]},
+    "a-watcher": {"endpoints": ["stock.watch"], "skus": ["sku-a*"]},
+    "b-watcher": {"endpoints": ["stock.watch"], "skus": ["sku-b*"]}
+  },
+  "bindings": [
+    {"tenant": "acme", "roles": ["reader"]},
+    {"tenant": "acme", "user": "barista", "roles": ["writer"]},
+    {"account": "ADMIN", "roles": ["reader", "writer"]},
+    {"tenant": "split", "roles": ["a-watcher", "b-watcher"]}
+  ]
+}`
+
+func TestAuthorize(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+
+	scenarios := []struct {
+		name      string
+		principal Principal
+		endpoint  string
+		skus      []string
+		allowed   bool
+	}{
+		{"reader can get", Principal{Tenant: "acme"}, "stock.get", []string{"tea-cup"}, true},
+		{"reader can renew a watch", Principal{Tenant: "acme"}, "stock.watch.renew", nil, true},
+		{"reader can't add", Principal{Tenant: "acme"}, "stock.add", []string{"coffee-cup"}, false},
+		{"writer can add matching sku", Principal{Tenant: "acme", User: "barista"}, "stock.add", []string{"coffee-cup"}, true},
+		{"writer can't remove other sku", Principal{Tenant: "acme", User: "barista"}, "stock.remove", []string{"tea-cup"}, false},
+		{"writer needs every sku to match", Principal{Tenant: "acme", User: "barista"}, "stock.remove", []string{"coffee-cup", "tea-cup"}, false},
+		{"reader role applies to every sku", Principal{Tenant: "acme", User: "barista"}, "stock.watch", []string{"coffee-cup", "tea-cup"}, true},
+		{"other tenant has no roles", Principal{Tenant: "other", User: "barista"}, "stock.get", []string{"coffee-cup"}, false},
+		{"account binding", Principal{Account: "ADMIN", Tenant: "any"}, "stock.remove", []string{"coffee-bean"}, true},
+		{"skus can be allowed by different roles", Principal{Tenant: "split"}, "stock.watch", []string{"sku-a1", "sku-b1"}, true},
+		{"every sku needs a role", Principal{Tenant: "split"}, "stock.watch", []string{"sku-a1", "sku-c1"}, false},
+		{"roles only allow skus for their own endpoints", Principal{Tenant: "split"}, "stock.get", []string{"sku-a1"}, false},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			err := policy.Authorize(scenario.principal, scenario.endpoint, scenario.skus)
+			if scenario.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrForbidden)
+			}
+		})
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	scenarios := []struct {
+		name   string
+		policy string
+	}{
+		{"not JSON", `roles`},
+		{"unknown field", `{"roles": {}, "bindings": [], "groups": []}`},
+		{"bad pattern", `{"roles": {"reader": {"endpoints": ["stock.[get"]}}}`},
+		{"unknown role", `{"roles": {}, "bindings": [{"tenant": "acme", "roles": ["reader"]}]}`},
+		{"binding without roles", `{"roles": {}, "bindings": [{"tenant": "acme"}]}`},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(scenario.policy))
+			require.ErrorIs(t, err, ErrBadPolicy)
+		})
+	}
+}
+
+func TestAuthorizerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(testPolicy), 0o600))
+
+	authorizer, err := NewFileAuthorizer(path)
+	require.NoError(t, err)
+	require.ErrorIs(t, authorizer.Authorize(Principal{Tenant: "acme"}, "stock.add", nil), ErrForbidden)
+
+	// Unchanged files are not reloaded
+	reloaded, err := authorizer.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// Grant every role to acme
+	updated := `{"roles": {"all": {"endpoints": ["*"]}}, "bindings": [{"tenant": "acme", "roles": ["all"]}]}`
+	require.NoError(t, os.WriteFile(path, []byte(updated), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	reloaded, err = authorizer.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.NoError(t, authorizer.Authorize(Principal{Tenant: "acme"}, "stock.add", nil))
+
+	// A broken file keeps the current policy
+	require.NoError(t, os.WriteFile(path, []byte(`{"roles":`), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	_, err = authorizer.Reload()
+	require.ErrorIs(t, err, ErrBadPolicy)
+	require.NoError(t, authorizer.Authorize(Principal{Tenant: "acme"}, "stock.add", nil))
+}
@@ -0,0 +1,174 @@
+// Package concurrency caps how many requests are handled at once. Requests over the cap wait in a short
+// queue for a slot, and are turned away at once when the queue is full, so callers get a fast answer
+// rather than waiting on a database connection.
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrOverloaded = errors.New("overloaded")
+	ErrBadLimit   = errors.New("invalid concurrency limit")
+)
+
+// ServiceScope names the service wide limit, in the queue depths
+const ServiceScope = "service"
+
+// DefaultMaxQueued and DefaultMaxWait keep the queue short, a request that waits longer than this is
+// better retried by the caller
+const (
+	DefaultMaxQueued = 16
+	DefaultMaxWait   = 100 * time.Millisecond
+)
+
+// Config sets how many requests may be handled at once
+type Config struct {
+	// MaxInFlight caps the requests handled at once across the service, 0 means there is no service wide cap
+	MaxInFlight int
+	// Endpoints caps the requests handled at once by individual endpoints, keyed by endpoint subject
+	Endpoints map[string]int
+	// MaxQueued is how many requests may wait for a slot under each cap
+	MaxQueued int
+	// MaxWait is the longest a request waits for a slot
+	MaxWait time.Duration
+}
+
+// Enabled reports whether the config caps any requests
+func (c Config) Enabled() bool {
+	return c.MaxInFlight > 0 || len(c.Endpoints) > 0
+}
+
+// ParseEndpointLimits parses a comma separated list of per endpoint caps, eg: "stock.add=4,stock.get=8"
+func ParseEndpointLimits(s string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		endpoint, limitStr, ok := strings.Cut(entry, "=")
+		if !ok || endpoint == "" {
+			return nil, fmt.Errorf("%w: %s", ErrBadLimit, entry)
+		}
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("%w: %s", ErrBadLimit, entry)
+		}
+		limits[endpoint] = limit
+	}
+	return limits, nil
+}
+
+// Limiter holds the slots for the service wide cap, and for each endpoint's cap
+type Limiter struct {
+	service   *slots
+	endpoints map[string]*slots
+}
+
+// NewLimiter returns a Limiter that applies the caps in config
+func NewLimiter(config Config) *Limiter {
+	l := &Limiter{endpoints: map[string]*slots{}}
+	if config.MaxInFlight > 0 {
+		l.service = newSlots(config.MaxInFlight, config.MaxQueued, config.MaxWait)
+	}
+	for endpoint, limit := range config.Endpoints {
+		l.endpoints[endpoint] = newSlots(limit, config.MaxQueued, config.MaxWait)
+	}
+	return l
+}
+
+// Acquire takes a slot for a request to the endpoint, waiting in the queue if need be. It returns a
+// function that gives the slot back once the request has been handled, and how long the request waited.
+// When the queue is full, or the request waits too long, it returns an error wrapping ErrOverloaded.
+func (l *Limiter) Acquire(ctx context.Context, endpoint string) (func(), time.Duration, error) {
+	// Take the endpoint's slot first, so a request waiting on a busy endpoint doesn't hold a service slot
+	releaseEndpoint, endpointWait, err := l.endpoints[endpoint].acquire(ctx)
+	if err != nil {
+		return nil, endpointWait, fmt.Errorf("%w: %s is handling too many requests", err, endpoint)
+	}
+	releaseService, serviceWait, err := l.service.acquire(ctx)
+	if err != nil {
+		releaseEndpoint()
+		return nil, endpointWait + serviceWait, fmt.Errorf("%w: the service is handling too many requests", err)
+	}
+	return func() {
+		releaseService()
+		releaseEndpoint()
+	}, endpointWait + serviceWait, nil
+}
+
+// QueueDepths returns the number of requests waiting under each cap, keyed by ServiceScope or the endpoint subject
+func (l *Limiter) QueueDepths() iter.Seq2[string, int] {
+	return func(yield func(string, int) bool) {
+		if l.service != nil && !yield(ServiceScope, l.service.queueDepth()) {
+			return
+		}
+		for endpoint, s := range l.endpoints {
+			if !yield(endpoint, s.queueDepth()) {
+				return
+			}
+		}
+	}
+}
+
+// slots caps the requests handled at once under one limit
+type slots struct {
+	inFlight  chan struct{}
+	queued    atomic.Int64
+	maxQueued int64
+	maxWait   time.Duration
+}
+
+func newSlots(limit, maxQueued int, maxWait time.Duration) *slots {
+	return &slots{
+		inFlight:  make(chan struct{}, limit),
+		maxQueued: int64(maxQueued),
+		maxWait:   maxWait,
+	}
+}
+
+func (s *slots) release() {
+	<-s.inFlight
+}
+
+// acquire takes a slot, a nil slots has no cap
+func (s *slots) acquire(ctx context.Context) (func(), time.Duration, error) {
+	if s == nil {
+		return func() {}, 0, nil
+	}
+	select {
+	case s.inFlight <- struct{}{}:
+		return s.release, 0, nil
+	default:
+	}
+
+	if s.queued.Add(1) > s.maxQueued {
+		s.queued.Add(-1)
+		return nil, 0, ErrOverloaded
+	}
+	defer s.queued.Add(-1)
+
+	start := time.Now()
+	timer := time.NewTimer(s.maxWait)
+	defer timer.Stop()
+	select {
+	case s.inFlight <- struct{}{}:
+		return s.release, time.Since(start), nil
+	case <-timer.C:
+		return nil, time.Since(start), ErrOverloaded
+	case <-ctx.Done():
+		return nil, time.Since(start), fmt.Errorf("%w: %w", ErrOverloaded, ctx.Err())
+	}
+}
+
+func (s *slots) queueDepth() int {
+	return int(s.queued.Load())
+}
@@ -0,0 +1,92 @@
+package concurrency
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpointLimits(t *testing.T) {
+	limits, err := ParseEndpointLimits("stock.add=4, stock.get=8")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"stock.add": 4, "stock.get": 8}, limits)
+
+	limits, err = ParseEndpointLimits("")
+	require.NoError(t, err)
+	require.Empty(t, limits)
+
+	for _, bad := range []string{"stock.add", "=4", "stock.add=many", "stock.add=0"} {
+		_, err := ParseEndpointLimits(bad)
+		require.ErrorIs(t, err, ErrBadLimit, bad)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(Config{
+		MaxInFlight: 2,
+		Endpoints:   map[string]int{"stock.add": 1},
+		MaxQueued:   1,
+		MaxWait:     50 * time.Millisecond,
+	})
+
+	// The endpoint cap is reached first
+	release, wait, err := limiter.Acquire(t.Context(), "stock.add")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+	_, wait, err = limiter.Acquire(t.Context(), "stock.add")
+	require.ErrorIs(t, err, ErrOverloaded)
+	require.GreaterOrEqual(t, wait, 50*time.Millisecond)
+
+	// Then the service wide cap
+	releaseGet, _, err := limiter.Acquire(t.Context(), "stock.get")
+	require.NoError(t, err)
+	_, _, err = limiter.Acquire(t.Context(), "stock.remove")
+	require.ErrorIs(t, err, ErrOverloaded)
+
+	// A queued request gets the slot when it is released
+	done := make(chan error)
+	go func() {
+		releaseQueued, _, err := limiter.Acquire(t.Context(), "stock.get")
+		if err == nil {
+			releaseQueued()
+		}
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		return maps.Collect(limiter.QueueDepths())[ServiceScope] == 1
+	}, time.Second, time.Millisecond)
+
+	// The queue is full, so this request is turned away without waiting
+	_, wait, err = limiter.Acquire(t.Context(), "stock.remove")
+	require.ErrorIs(t, err, ErrOverloaded)
+	require.Zero(t, wait)
+
+	releaseGet()
+	require.NoError(t, <-done)
+	release()
+	require.Equal(t, map[string]int{ServiceScope: 0, "stock.add": 0}, maps.Collect(limiter.QueueDepths()))
+}
+
+func TestLimiterCancelled(t *testing.T) {
+	limiter := NewLimiter(Config{MaxInFlight: 1, MaxQueued: 1, MaxWait: time.Minute})
+	release, _, err := limiter.Acquire(t.Context(), "stock.get")
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = limiter.Acquire(ctx, "stock.get")
+	require.ErrorIs(t, err, ErrOverloaded)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLimiterWithoutCaps(t *testing.T) {
+	limiter := NewLimiter(Config{})
+	for range 100 {
+		_, _, err := limiter.Acquire(t.Context(), "stock.get")
+		require.NoError(t, err)
+	}
+}
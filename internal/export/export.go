@@ -0,0 +1,250 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/davidoram/beaker/internal/db"
+	"github.com/davidoram/beaker/internal/telemetry"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Format is the output format of an inventory export
+type Format string
+
+const (
+	FormatCSV    Format = "csv"
+	FormatNDJSON Format = "ndjson"
+)
+
+// Column is the name of a column that can be selected for export. The names match the
+// property names used in the inventory-record.json schema.
+type Column string
+
+const (
+	ColumnProductSKU Column = "product-sku"
+	ColumnStockLevel Column = "stock-level"
+)
+
+// AllColumns lists every column that can be exported, in their default order
+var AllColumns = []Column{ColumnProductSKU, ColumnStockLevel}
+
+const DefaultPageSize = 1000
+
+var (
+	ErrBadFormat   = errors.New("invalid export format")
+	ErrBadColumn   = errors.New("invalid export column")
+	ErrNoColumns   = errors.New("no export columns selected")
+	ErrBadPageSize = errors.New("invalid export page size")
+)
+
+// ParseFormat converts a format name into a Format
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatCSV, FormatNDJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrBadFormat, s)
+}
+
+// ParseColumns converts a comma separated list of column names into a slice of Column
+func ParseColumns(s string) ([]Column, error) {
+	var columns []Column
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		column := Column(name)
+		if column != ColumnProductSKU && column != ColumnStockLevel {
+			return nil, fmt.Errorf("%w: %s", ErrBadColumn, name)
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, ErrNoColumns
+	}
+	return columns, nil
+}
+
+// Options controls what an export writes
+type Options struct {
+	Tenant   string
+	Format   Format
+	Columns  []Column
+	PageSize int
+}
+
+// RecordWriter writes inventory rows in a specific format
+type RecordWriter interface {
+	Write(inventory db.Inventory) error
+	Flush() error
+}
+
+// NewRecordWriter returns a RecordWriter for the format, that writes the selected columns to w.
+// NDJSON records are validated against schema before they are written, pass a nil schema to skip validation.
+func NewRecordWriter(w io.Writer, format Format, columns []Column, schema *jsonschema.Schema) (RecordWriter, error) {
+	if len(columns) == 0 {
+		return nil, ErrNoColumns
+	}
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatNDJSON:
+		return &ndjsonWriter{w: w, columns: columns, schema: schema}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrBadFormat, format)
+}
+
+// Run writes a snapshot of a tenant's inventory to w. The snapshot is read inside a single
+// repeatable read, read only transaction, so the output is consistent even if the table is
+// being updated while the export runs. Rows are read a page at a time so the whole table is
+// never held in memory.
+func Run(ctx context.Context, pool *pgxpool.Pool, compiler *jsonschema.Compiler, w io.Writer, opts Options) (int, error) {
+	tracer := telemetry.GetTracer()
+	ctx, span := tracer.Start(ctx, "export inventory")
+	defer span.End()
+
+	count, err := run(ctx, pool, compiler, w, opts)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return count, err
+}
+
+func run(ctx context.Context, pool *pgxpool.Pool, compiler *jsonschema.Compiler, w io.Writer, opts Options) (int, error) {
+	if opts.PageSize <= 0 {
+		return 0, ErrBadPageSize
+	}
+
+	var schema *jsonschema.Schema
+	if opts.Format == FormatNDJSON {
+		var err error
+		schema, err = compiler.Compile(schemas.InventoryRecordSchema)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compile schema %s: %w", schemas.InventoryRecordSchema, err)
+		}
+	}
+	writer, err := NewRecordWriter(w, opts.Format, opts.Columns, schema)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Read only, so there is never anything to commit
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	queries := db.New(tx)
+	if err := queries.SetTenant(ctx, opts.Tenant); err != nil {
+		return 0, err
+	}
+	count := 0
+	params := db.ListInventoryPageParams{TenantID: opts.Tenant, PageSize: int32(opts.PageSize)}
+	for {
+		page, err := queries.ListInventoryPage(ctx, params)
+		if err != nil {
+			return count, err
+		}
+		for _, inventory := range page {
+			if err := writer.Write(inventory); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(page) < opts.PageSize {
+			break
+		}
+		params.AfterSku = page[len(page)-1].ProductSku
+	}
+	return count, writer.Flush()
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = string(column)
+	}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) Write(inventory db.Inventory) error {
+	row := make([]string, len(cw.columns))
+	for i, column := range cw.columns {
+		switch column {
+		case ColumnProductSKU:
+			row[i] = inventory.ProductSku
+		case ColumnStockLevel:
+			row[i] = strconv.Itoa(int(inventory.StockLevel))
+		}
+	}
+	return cw.w.Write(row)
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	w       io.Writer
+	columns []Column
+	schema  *jsonschema.Schema
+}
+
+func (nw *ndjsonWriter) Write(inventory db.Inventory) error {
+	record := schemas.InventoryRecord{}
+	for _, column := range nw.columns {
+		switch column {
+		case ColumnProductSKU:
+			record.ProductSKU = utility.Ptr(inventory.ProductSku)
+		case ColumnStockLevel:
+			record.StockLevel = utility.Ptr(int(inventory.StockLevel))
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if nw.schema != nil {
+		data, err := jsonschema.UnmarshalJSON(bytes.NewReader(line))
+		if err != nil {
+			return err
+		}
+		if err := nw.schema.Validate(data); err != nil {
+			return fmt.Errorf("record for %s does not conform to schema %s: %w", inventory.ProductSku, schemas.InventoryRecordSchema, err)
+		}
+	}
+	_, err = nw.w.Write(append(line, '\n'))
+	return err
+}
+
+func (nw *ndjsonWriter) Flush() error {
+	return nil
+}
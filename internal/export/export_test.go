@@ -0,0 +1,65 @@
+package export
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidoram/beaker/internal/db"
+	"github.com/davidoram/beaker/internal/utility"
+	"github.com/davidoram/beaker/schemas"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns("stock-level, product-sku")
+	require.NoError(t, err)
+	require.Equal(t, []Column{ColumnStockLevel, ColumnProductSKU}, columns)
+
+	_, err = ParseColumns("product-sku,colour")
+	require.ErrorIs(t, err, ErrBadColumn)
+
+	_, err = ParseColumns(" , ")
+	require.ErrorIs(t, err, ErrNoColumns)
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("NDJSON")
+	require.NoError(t, err)
+	require.Equal(t, FormatNDJSON, format)
+
+	_, err = ParseFormat("xml")
+	require.ErrorIs(t, err, ErrBadFormat)
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewRecordWriter(&buf, FormatCSV, []Column{ColumnStockLevel, ColumnProductSKU}, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Write(db.Inventory{ProductSku: "coffee-cup", StockLevel: 10}))
+	require.NoError(t, writer.Write(db.Inventory{ProductSku: "coaster", StockLevel: 0}))
+	require.NoError(t, writer.Flush())
+
+	require.Equal(t, "stock-level,product-sku\n10,coffee-cup\n0,coaster\n", buf.String())
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	compiler, err := utility.NewJSONSchemaCompiler(t.Context(), filepath.Join("..", "..", "schemas"))
+	require.NoError(t, err)
+	schema, err := compiler.Compile(schemas.InventoryRecordSchema)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writer, err := NewRecordWriter(&buf, FormatNDJSON, AllColumns, schema)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Write(db.Inventory{ProductSku: "coffee-cup", StockLevel: 10}))
+	require.NoError(t, writer.Write(db.Inventory{ProductSku: "coaster", StockLevel: 0}))
+	require.NoError(t, writer.Flush())
+
+	require.Equal(t, "{\"product-sku\":\"coffee-cup\",\"stock-level\":10}\n{\"product-sku\":\"coaster\",\"stock-level\":0}\n", buf.String())
+
+	// Records that do not conform to the schema are rejected
+	require.Error(t, writer.Write(db.Inventory{ProductSku: "coffee-cup", StockLevel: -1}))
+}
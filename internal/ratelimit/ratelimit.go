@@ -0,0 +1,158 @@
+// Package ratelimit limits how often each caller may use each endpoint, using token buckets.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+	ErrBadLimit    = errors.New("invalid rate limit")
+)
+
+// idleTimeout is how long a caller's bucket is kept after its last request. An idle bucket has
+// refilled, so forgetting it doesn't change any decisions.
+const idleTimeout = 10 * time.Minute
+
+// Limit is the size of a token bucket. Rate tokens are added every second, up to a maximum of Burst.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit written as "rate:burst", eg: "10:20", or just "rate", in which case the
+// burst is the rate rounded up
+func ParseLimit(s string) (Limit, error) {
+	rateStr, burstStr, hasBurst := strings.Cut(strings.TrimSpace(s), ":")
+	r, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || r <= 0 || math.IsInf(r, 0) {
+		return Limit{}, fmt.Errorf("%w: %s", ErrBadLimit, s)
+	}
+	limit := Limit{Rate: r, Burst: int(math.Ceil(r))}
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burstStr)
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("%w: %s", ErrBadLimit, s)
+		}
+	}
+	return limit, nil
+}
+
+// ParseEndpointLimits parses a comma separated list of per endpoint limits, eg: "stock.add=5:10,stock.remove=5"
+func ParseEndpointLimits(s string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		endpoint, limitStr, ok := strings.Cut(entry, "=")
+		if !ok || endpoint == "" {
+			return nil, fmt.Errorf("%w: %s", ErrBadLimit, entry)
+		}
+		limit, err := ParseLimit(limitStr)
+		if err != nil {
+			return nil, err
+		}
+		limits[endpoint] = limit
+	}
+	return limits, nil
+}
+
+// Config sets the limits that apply to each caller
+type Config struct {
+	// Default applies to every endpoint that doesn't have its own limit, nil means those endpoints are not limited
+	Default *Limit
+	// Endpoints holds the limits for individual endpoints, keyed by endpoint subject
+	Endpoints map[string]Limit
+}
+
+// Limiter keeps a token bucket for every caller and endpoint
+type Limiter struct {
+	config Config
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	caller   string
+	endpoint string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// NewLimiter returns a Limiter that applies the limits in config
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		buckets:   map[bucketKey]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the caller's bucket for the endpoint. If the bucket is empty it returns
+// an error wrapping ErrRateLimited, and how long the caller should wait before trying again.
+func (l *Limiter) Allow(caller, endpoint string) (time.Duration, error) {
+	limit, ok := l.limitFor(endpoint)
+	if !ok {
+		return 0, nil
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	key := bucketKey{caller: caller, endpoint: endpoint}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.buckets[key] = b
+	}
+	b.lastUsed = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Don't hold the token, the caller is being turned away
+		reservation.CancelAt(now)
+		return delay, fmt.Errorf("%w: retry %s after %s", ErrRateLimited, endpoint, delay.Round(time.Millisecond))
+	}
+	return 0, nil
+}
+
+func (l *Limiter) limitFor(endpoint string) (Limit, bool) {
+	if limit, ok := l.config.Endpoints[endpoint]; ok {
+		return limit, true
+	}
+	if l.config.Default != nil {
+		return *l.config.Default, true
+	}
+	return Limit{}, false
+}
+
+// sweep forgets buckets that have been idle for a while, so callers that come and go don't use
+// up memory. It must be called with the lock held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleTimeout {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastUsed) >= idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10:20")
+	require.NoError(t, err)
+	require.Equal(t, Limit{Rate: 10, Burst: 20}, limit)
+
+	limit, err = ParseLimit("0.5")
+	require.NoError(t, err)
+	require.Equal(t, Limit{Rate: 0.5, Burst: 1}, limit)
+
+	for _, bad := range []string{"", "fast", "0", "-1", "10:", "10:0", "10:x"} {
+		_, err := ParseLimit(bad)
+		require.ErrorIs(t, err, ErrBadLimit, bad)
+	}
+}
+
+func TestParseEndpointLimits(t *testing.T) {
+	limits, err := ParseEndpointLimits("stock.add=5:10, stock.remove=1")
+	require.NoError(t, err)
+	require.Equal(t, map[string]Limit{
+		"stock.add":    {Rate: 5, Burst: 10},
+		"stock.remove": {Rate: 1, Burst: 1},
+	}, limits)
+
+	limits, err = ParseEndpointLimits("")
+	require.NoError(t, err)
+	require.Empty(t, limits)
+
+	for _, bad := range []string{"stock.add", "=5", "stock.add=fast"} {
+		_, err := ParseEndpointLimits(bad)
+		require.ErrorIs(t, err, ErrBadLimit, bad)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(Config{
+		Endpoints: map[string]Limit{"stock.add": {Rate: 1, Burst: 2}},
+	})
+
+	// The burst is allowed, then the caller has to wait
+	for range 2 {
+		_, err := limiter.Allow("caller-a", "stock.add")
+		require.NoError(t, err)
+	}
+	retryAfter, err := limiter.Allow("caller-a", "stock.add")
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.Greater(t, retryAfter, time.Duration(0))
+	require.LessOrEqual(t, retryAfter, time.Second)
+
+	// Other callers have their own bucket
+	_, err = limiter.Allow("caller-b", "stock.add")
+	require.NoError(t, err)
+
+	// Endpoints without a limit are not limited
+	for range 10 {
+		_, err := limiter.Allow("caller-a", "stock.get")
+		require.NoError(t, err)
+	}
+}
+
+func TestLimiterDefault(t *testing.T) {
+	limiter := NewLimiter(Config{
+		Default:   &Limit{Rate: 1, Burst: 1},
+		Endpoints: map[string]Limit{"stock.get": {Rate: 100, Burst: 100}},
+	})
+
+	_, err := limiter.Allow("caller-a", "stock.add")
+	require.NoError(t, err)
+	_, err = limiter.Allow("caller-a", "stock.add")
+	require.ErrorIs(t, err, ErrRateLimited)
+
+	// The endpoint limit overrides the default
+	for range 10 {
+		_, err := limiter.Allow("caller-a", "stock.get")
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,124 @@
+package schemagen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// object is a JSON object that remembers the order of its keys, so the fields of a generated struct are
+// in the same order as the properties of its schema
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+// parseObject parses a JSON document that must be an object. Objects nested in it are parsed as *object,
+// and numbers as json.Number.
+func parseObject(data []byte) (*object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := parseValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := v.(*object)
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON object, got %T", v)
+	}
+	return o, nil
+}
+
+func parseValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		o := &object{values: map[string]any{}}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			o.keys = append(o.keys, key.(string))
+			o.values[key.(string)] = v
+		}
+		_, err := dec.Token()
+		return o, err
+	case json.Delim('['):
+		a := []any{}
+		for dec.More() {
+			v, err := parseValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err := dec.Token()
+		return a, err
+	}
+	return tok, nil
+}
+
+// str returns the string value of key, or "" if it is missing or not a string
+func (o *object) str(key string) string {
+	if o == nil {
+		return ""
+	}
+	s, _ := o.values[key].(string)
+	return s
+}
+
+// obj returns the object value of key, or nil if it is missing or not an object
+func (o *object) obj(key string) *object {
+	if o == nil {
+		return nil
+	}
+	v, _ := o.values[key].(*object)
+	return v
+}
+
+// objs returns the objects in the array value of key
+func (o *object) objs(key string) []*object {
+	if o == nil {
+		return nil
+	}
+	a, _ := o.values[key].([]any)
+	var objs []*object
+	for _, v := range a {
+		if v, ok := v.(*object); ok {
+			objs = append(objs, v)
+		}
+	}
+	return objs
+}
+
+// strs returns the strings in the array value of key
+func (o *object) strs(key string) []string {
+	if o == nil {
+		return nil
+	}
+	a, _ := o.values[key].([]any)
+	var strs []string
+	for _, v := range a {
+		if v, ok := v.(string); ok {
+			strs = append(strs, v)
+		}
+	}
+	return strs
+}
+
+// has reports whether the object has the key
+func (o *object) has(key string) bool {
+	if o == nil {
+		return false
+	}
+	_, ok := o.values[key]
+	return ok
+}
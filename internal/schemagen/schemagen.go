@@ -0,0 +1,512 @@
+// Package schemagen generates the Go types of the JSON schemas in the schemas package, so the types can't
+// drift from the schemas that requests, responses and events are validated against.
+//
+// Each schema with an object at its root becomes a struct named after the schema's file, without its
+// version, eg: `low-stock.v1.event.json` becomes LowStockEvent. A property becomes a field, a property
+// that isn't required is a pointer, or a slice or map, tagged omitempty. Every schema's `$id` becomes a
+// constant, eg: LowStockEventSchema. Schemas are read with these annotations, which validators ignore:
+//
+//   - `x-go-type` names the type of an enum, or of an object nested in a schema. An enum's values become
+//     constants, with comments from `x-enum-descriptions`, an object of value to description.
+//   - `x-event` marks an event schema, with the CloudEvents `type` of the event, and the `subject` it is
+//     published to, eg: `events.stock.changed.{tenant}.{product-sku}`. The `{tenant}` placeholder is
+//     filled from a TenantID field that isn't part of the payload, other placeholders name properties.
+//
+// A schema with a `oneOf` of a success and an error object is a response. Its struct has the fields of
+// both, and implements schemas.APIResponse.
+package schemagen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Header starts every generated file. Go tools recognise the file as generated from it.
+const Header = "// Code generated by schemagen from the JSON schemas in this directory. DO NOT EDIT."
+
+// initialisms are the words of a name that are written in capitals in Go
+var initialisms = map[string]string{
+	"api":  "API",
+	"gtin": "GTIN",
+	"http": "HTTP",
+	"id":   "ID",
+	"json": "JSON",
+	"nats": "NATS",
+	"ok":   "OK",
+	"sku":  "SKU",
+	"skus": "SKUs",
+	"url":  "URL",
+}
+
+var (
+	versionPattern     = regexp.MustCompile(`^v[0-9]+$`)
+	placeholderPattern = regexp.MustCompile(`\{([a-z0-9-]+)\}`)
+)
+
+// Generate returns the formatted Go source of the types of every JSON schema in dir, in package pkg
+func Generate(dir, pkg string) ([]byte, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(paths)
+	g := &generator{byID: map[string]*schemaFile{}, declared: map[string]declaration{}, imports: map[string]bool{}}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		root, err := parseObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		f := &schemaFile{file: filepath.Base(path), id: root.str("$id"), root: root}
+		if f.id == "" {
+			return nil, fmt.Errorf("%s: schema has no $id", f.file)
+		}
+		f.name = TypeName(f.file)
+		g.files = append(g.files, f)
+		g.byID[f.id] = f
+	}
+	for _, f := range g.files {
+		if err := g.schema(f); err != nil {
+			return nil, err
+		}
+	}
+	return g.source(pkg)
+}
+
+// TypeName returns the name of the Go type of the schema in file, eg: `low-stock.v1.event.json` is LowStockEvent
+func TypeName(file string) string {
+	var parts []string
+	for _, part := range strings.Split(strings.TrimSuffix(file, ".json"), ".") {
+		if !versionPattern.MatchString(part) {
+			parts = append(parts, part)
+		}
+	}
+	return GoName(strings.Join(parts, "-"))
+}
+
+// GoName returns the exported Go name of a kebab or snake case JSON name, eg: `product-sku` is ProductSKU
+func GoName(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+		if initialism, ok := initialisms[word]; ok {
+			b.WriteString(initialism)
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+type schemaFile struct {
+	file string
+	id   string
+	name string
+	root *object
+}
+
+// declaration records where a named type was declared, and what it holds, so a type named in more than
+// one schema is declared once, and only if every schema agrees on it
+type declaration struct {
+	where string
+	key   string
+}
+
+type generator struct {
+	files    []*schemaFile
+	byID     map[string]*schemaFile
+	declared map[string]declaration
+	imports  map[string]bool
+	// decls holds the source of each declaration, in the order they are written out
+	decls []string
+}
+
+type field struct {
+	name string
+	typ  string
+	tag  string
+	doc  string
+}
+
+type fieldGroup struct {
+	doc    string
+	fields []field
+}
+
+// isObject reports whether the schema declares a struct, rather than a value used by other schemas
+func isObject(s *object) bool {
+	return s.has("oneOf") || (s.str("type") == "object" && s.has("properties"))
+}
+
+// reserve adds a placeholder for a declaration, so a type is written out before the types it uses
+func (g *generator) reserve() int {
+	g.decls = append(g.decls, "")
+	return len(g.decls) - 1
+}
+
+func (g *generator) schema(f *schemaFile) error {
+	if !isObject(f.root) {
+		return nil
+	}
+	where := "the " + f.file + " schema"
+	doc := comment("", fmt.Sprintf("%s corresponds to the %s schema.", f.name, f.file), f.root.str("description"))
+	switch {
+	case f.root.has("oneOf"):
+		return g.response(f, where, doc)
+	case f.root.has("x-event"):
+		return g.event(f, where, doc)
+	}
+	idx := g.reserve()
+	fields, err := g.fields(f.root, where)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.WriteString(doc)
+	fmt.Fprintf(&b, "type %s struct {\n%s}\n\n", f.name, renderFields([]fieldGroup{{fields: fields}}))
+	writeSchemaMethod(&b, f)
+	g.decls[idx] = b.String()
+	return nil
+}
+
+// response declares the struct of a response schema, with the fields of its success and error objects
+func (g *generator) response(f *schemaFile, where, doc string) error {
+	idx := g.reserve()
+	ok := field{name: "OK", typ: "bool", tag: "`json:\"ok\"`"}
+	success := fieldGroup{doc: "Success response fields"}
+	failure := fieldGroup{doc: "Error response fields"}
+	seen := map[string]bool{}
+	for _, branch := range f.root.objs("oneOf") {
+		props := branch.obj("properties")
+		okProp := props.obj("ok")
+		isOK, isBool := okProp.values["const"].(bool)
+		if !isBool {
+			return fmt.Errorf("%s: each object in oneOf needs an ok property with a const value", where)
+		}
+		ok.doc = okProp.str("description")
+		group := &failure
+		if isOK {
+			group = &success
+		}
+		for _, key := range props.keys {
+			if key == "ok" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			// Only one of the objects is present in a response, so every field is optional
+			fd, err := g.field(key, props.obj(key), false, where)
+			if err != nil {
+				return err
+			}
+			group.fields = append(group.fields, fd)
+		}
+	}
+	if !slices.ContainsFunc(failure.fields, func(f field) bool { return f.name == "Error" }) ||
+		!slices.ContainsFunc(failure.fields, func(f field) bool { return f.name == "ErrorDetail" }) {
+		return fmt.Errorf("%s: the error object in oneOf needs error and error-detail properties", where)
+	}
+	g.imports["github.com/davidoram/beaker/internal/utility"] = true
+
+	var b strings.Builder
+	b.WriteString(doc)
+	fmt.Fprintf(&b, "type %s struct {\n%s}\n\n", f.name, renderFields([]fieldGroup{{fields: []field{ok}}, success, failure}))
+	b.WriteString("// SetErrorAttributes sets the error response fields, and clears the success response fields\n")
+	fmt.Fprintf(&b, "func (r *%s) SetErrorAttributes(err error, detail *ErrorDetail) {\n", f.name)
+	b.WriteString("\tr.Error = utility.Ptr(err.Error())\n\tr.ErrorDetail = detail\n\tr.OK = false\n")
+	if len(success.fields) > 0 {
+		b.WriteString("\n")
+	}
+	for _, fd := range success.fields {
+		fmt.Fprintf(&b, "\tr.%s = nil\n", fd.name)
+	}
+	b.WriteString("}\n\n")
+	writeSchemaMethod(&b, f)
+	g.decls[idx] = b.String()
+	return nil
+}
+
+// event declares the struct of an event schema, with the methods of schemas.Event
+func (g *generator) event(f *schemaFile, where, doc string) error {
+	idx := g.reserve()
+	ev := f.root.obj("x-event")
+	eventType, subject := ev.str("type"), ev.str("subject")
+	if eventType == "" || subject == "" {
+		return fmt.Errorf("%s: x-event needs a type and a subject", where)
+	}
+	fields, err := g.fields(f.root, where)
+	if err != nil {
+		return err
+	}
+
+	// The subject is built by joining its literal parts with the fields named by its placeholders
+	var parts []string
+	tenant := false
+	literal := func(s string) {
+		if s != "" {
+			parts = append(parts, fmt.Sprintf("%q", s))
+		}
+	}
+	last := 0
+	for _, m := range placeholderPattern.FindAllStringSubmatchIndex(subject, -1) {
+		literal(subject[last:m[0]])
+		last = m[1]
+		name := subject[m[2]:m[3]]
+		if name == "tenant" {
+			tenant = true
+			parts = append(parts, "e.TenantID")
+			continue
+		}
+		i := slices.IndexFunc(fields, func(f field) bool { return f.tag == fmt.Sprintf("`json:%q`", name) })
+		if i < 0 || fields[i].typ != "string" {
+			return fmt.Errorf("%s: subject placeholder {%s} must name a required string property", where, name)
+		}
+		parts = append(parts, "e."+fields[i].name)
+	}
+	literal(subject[last:])
+	if tenant {
+		fields = append([]field{{name: "TenantID", typ: "string", tag: "`json:\"-\"`", doc: "TenantID is carried in the subject rather than the payload"}}, fields...)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %sType is the CloudEvents type of %s\nconst %sType = %q\n\n", f.name, f.name, f.name, eventType)
+	b.WriteString(doc)
+	fmt.Fprintf(&b, "type %s struct {\n%s}\n\n", f.name, renderFields([]fieldGroup{{fields: fields}}))
+	fmt.Fprintf(&b, "// Subject returns the NATS subject that %s is published to, `%s`\n", f.name, subject)
+	fmt.Fprintf(&b, "func (e %s) Subject() string {\n\treturn %s\n}\n\n", f.name, strings.Join(parts, " + "))
+	fmt.Fprintf(&b, "// Type returns the CloudEvents type of %s\n", f.name)
+	fmt.Fprintf(&b, "func (e %s) Type() string {\n\treturn %sType\n}\n\n", f.name, f.name)
+	fmt.Fprintf(&b, "// DataSchema returns the ID of the schema that %s conforms to\n", f.name)
+	fmt.Fprintf(&b, "func (e %s) DataSchema() string {\n\treturn %sSchema\n}\n", f.name, f.name)
+	g.decls[idx] = b.String()
+	return nil
+}
+
+func writeSchemaMethod(b *strings.Builder, f *schemaFile) {
+	fmt.Fprintf(b, "// Schema returns the ID of the %s schema\n", f.file)
+	fmt.Fprintf(b, "func (r *%s) Schema() string {\n\treturn %sSchema\n}\n", f.name, f.name)
+}
+
+// fields returns a field for each property of an object schema
+func (g *generator) fields(s *object, where string) ([]field, error) {
+	required := s.strs("required")
+	props := s.obj("properties")
+	var fields []field
+	for _, key := range props.keys {
+		fd, err := g.field(key, props.obj(key), slices.Contains(required, key), where)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fd)
+	}
+	return fields, nil
+}
+
+func (g *generator) field(key string, prop *object, required bool, where string) (field, error) {
+	if prop == nil {
+		return field{}, fmt.Errorf("%s: property %s is not a schema", where, key)
+	}
+	typ, err := g.goType(prop, fmt.Sprintf("the %s property of %s", key, where))
+	if err != nil {
+		return field{}, err
+	}
+	tag := key
+	if !required {
+		tag += ",omitempty"
+		if !strings.HasPrefix(typ, "[]") && !strings.HasPrefix(typ, "map[") {
+			typ = "*" + typ
+		}
+	}
+	doc := prop.str("description")
+	if target, ok := g.byID[prop.str("$ref")]; ok && doc == "" && !isObject(target.root) {
+		doc = target.root.str("description")
+	}
+	return field{name: GoName(key), typ: typ, tag: fmt.Sprintf("`json:%q`", tag), doc: doc}, nil
+}
+
+// goType returns the Go type of a schema, declaring the enums and nested objects it names
+func (g *generator) goType(s *object, where string) (string, error) {
+	if ref := s.str("$ref"); ref != "" {
+		target, ok := g.byID[ref]
+		if !ok {
+			return "", fmt.Errorf("%s: $ref to an unknown schema %s", where, ref)
+		}
+		if isObject(target.root) {
+			return target.name, nil
+		}
+		return g.goType(target.root, "the "+target.file+" schema")
+	}
+	if name := s.str("x-go-type"); name != "" {
+		switch {
+		case s.has("enum"):
+			return name, g.enum(name, s, where)
+		case isObject(s):
+			return name, g.nested(name, s, where)
+		}
+		return "", fmt.Errorf("%s: x-go-type names enums and objects with properties", where)
+	}
+	switch s.str("type") {
+	case "string":
+		if s.str("format") == "date-time" {
+			g.imports["time"] = true
+			return "time.Time", nil
+		}
+		return "string", nil
+	case "integer":
+		return "int", nil
+	case "number":
+		return "float64", nil
+	case "boolean":
+		return "bool", nil
+	case "array":
+		items := s.obj("items")
+		if items == nil {
+			return "", fmt.Errorf("%s: array has no items schema", where)
+		}
+		typ, err := g.goType(items, "the items of "+where)
+		return "[]" + typ, err
+	case "object":
+		if !s.has("properties") {
+			return "map[string]any", nil
+		}
+		return "", fmt.Errorf("%s: an object nested in a schema needs an x-go-type to name it", where)
+	}
+	return "", fmt.Errorf("%s: unsupported type %q", where, s.str("type"))
+}
+
+// declare reports whether the named type still needs declaring, and fails if it was declared differently
+func (g *generator) declare(name, where, key string) (bool, error) {
+	if prev, ok := g.declared[name]; ok {
+		if prev.key != key {
+			return false, fmt.Errorf("%s: x-go-type %s differs from %s", where, name, prev.where)
+		}
+		return false, nil
+	}
+	g.declared[name] = declaration{where: where, key: key}
+	return true, nil
+}
+
+func (g *generator) enum(name string, s *object, where string) error {
+	if s.str("type") != "string" {
+		return fmt.Errorf("%s: only string enums are supported", where)
+	}
+	values := s.strs("enum")
+	needed, err := g.declare(name, where, strings.Join(values, "\n"))
+	if err != nil || !needed {
+		return err
+	}
+	descriptions := s.obj("x-enum-descriptions")
+	var b strings.Builder
+	b.WriteString(comment("", fmt.Sprintf("%s holds the values of %s.", name, where), s.str("description")))
+	fmt.Fprintf(&b, "type %s string\n\nconst (\n", name)
+	for _, v := range values {
+		constant := name + GoName(v)
+		if d := descriptions.str(v); d != "" {
+			b.WriteString(comment("\t", constant+" "+d))
+		}
+		fmt.Fprintf(&b, "\t%s %s = %q\n", constant, name, v)
+	}
+	b.WriteString(")\n")
+	g.decls = append(g.decls, b.String())
+	return nil
+}
+
+func (g *generator) nested(name string, s *object, where string) error {
+	idx := g.reserve()
+	fields, err := g.fields(s, where)
+	if err != nil {
+		return err
+	}
+	body := renderFields([]fieldGroup{{fields: fields}})
+	if needed, err := g.declare(name, where, body); err != nil || !needed {
+		return err
+	}
+	g.decls[idx] = comment("", fmt.Sprintf("%s is the type of %s.", name, where), s.str("description")) +
+		fmt.Sprintf("type %s struct {\n%s}\n", name, body)
+	return nil
+}
+
+func renderFields(groups []fieldGroup) string {
+	var b strings.Builder
+	first := true
+	for _, group := range groups {
+		if len(group.fields) == 0 {
+			continue
+		}
+		if !first {
+			b.WriteString("\n")
+		}
+		first = false
+		if group.doc != "" {
+			b.WriteString(comment("\t", group.doc) + "\n")
+		}
+		for _, f := range group.fields {
+			b.WriteString(comment("\t", f.doc))
+			fmt.Fprintf(&b, "\t%s %s %s\n", f.name, f.typ, f.tag)
+		}
+	}
+	return b.String()
+}
+
+func isModulePath(path string) bool {
+	return strings.Contains(path, ".")
+}
+
+// comment returns the lines as a comment, skipping empty lines
+func comment(indent string, lines ...string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			fmt.Fprintf(&b, "%s// %s\n", indent, line)
+		}
+	}
+	return b.String()
+}
+
+func (g *generator) source(pkg string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n\npackage %s\n\n", Header, pkg)
+	if len(g.imports) > 0 {
+		b.WriteString("import (\n")
+		imports := make([]string, 0, len(g.imports))
+		for path := range g.imports {
+			imports = append(imports, path)
+		}
+		slices.Sort(imports)
+		// The standard library is grouped first, its paths have no dots
+		stdlib := true
+		for _, path := range slices.Concat(
+			slices.DeleteFunc(slices.Clone(imports), isModulePath),
+			slices.DeleteFunc(imports, func(path string) bool { return !isModulePath(path) }),
+		) {
+			if stdlib && isModulePath(path) {
+				stdlib = false
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "\t%q\n", path)
+		}
+		b.WriteString(")\n\n")
+	}
+	b.WriteString("// IDs of the JSON schemas\nconst (\n")
+	for _, f := range g.files {
+		fmt.Fprintf(&b, "\t%sSchema = %q\n", f.name, f.id)
+	}
+	b.WriteString(")\n")
+	for _, decl := range g.decls {
+		if decl != "" {
+			b.WriteString("\n" + decl)
+		}
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid Go: %w\n%s", err, b.Bytes())
+	}
+	return src, nil
+}
@@ -0,0 +1,106 @@
+package schemagen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeName(t *testing.T) {
+	assert.Equal(t, "StockAddRequest", TypeName("stock-add.request.json"))
+	assert.Equal(t, "LowStockEvent", TypeName("low-stock.v1.event.json"))
+	assert.Equal(t, "ProductSKU", TypeName("product-sku.json"))
+	assert.Equal(t, "ProductSKUs", GoName("product-skus"))
+	assert.Equal(t, "ErrorCodeValidationFailed", "ErrorCode"+GoName("validation_failed"))
+	assert.Equal(t, "LatencyMs", GoName("latency-ms"))
+}
+
+// TestGeneratedSchemasAreCurrent fails when a schema has changed without `go generate ./schemas` being run
+func TestGeneratedSchemasAreCurrent(t *testing.T) {
+	dir := filepath.Join("..", "..", "schemas")
+	src, err := Generate(dir, "schemas")
+	require.NoError(t, err)
+	committed, err := os.ReadFile(filepath.Join(dir, "schemas_gen.go"))
+	require.NoError(t, err)
+	assert.Equal(t, string(src), string(committed), "schemas/schemas_gen.go is stale, run `go generate ./schemas`")
+}
+
+func writeSchemas(t *testing.T, schemas map[string]string) string {
+	dir := t.TempDir()
+	for name, schema := range schemas {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(schema), 0o644))
+	}
+	return dir
+}
+
+func TestGenerate(t *testing.T) {
+	dir := writeSchemas(t, map[string]string{
+		"code.json": `{"$id": "http://example.com/code.json", "type": "string", "pattern": "^[a-z]+$", "description": "A code."}`,
+		"error-detail.json": `{"$id": "http://example.com/error-detail.json", "type": "object",
+			"properties": {"message": {"type": "string"}}, "required": ["message"]}`,
+		"thing-get.response.json": `{"$id": "http://example.com/thing-get.response.json", "oneOf": [
+			{"type": "object", "properties": {"ok": {"const": false}, "error": {"type": "string"},
+				"error-detail": {"$ref": "http://example.com/error-detail.json"}}},
+			{"type": "object", "properties": {"ok": {"const": true}, "code": {"$ref": "http://example.com/code.json"},
+				"created-at": {"type": "string", "format": "date-time"}}}]}`,
+		"thing.v2.event.json": `{"$id": "http://example.com/thing.v2.event.json", "type": "object",
+			"x-event": {"type": "com.example.thing.v2", "subject": "events.thing.{tenant}.{code}"},
+			"properties": {
+				"code": {"$ref": "http://example.com/code.json"},
+				"colour": {"type": "string", "enum": ["red", "light_blue"], "x-go-type": "Colour", "x-enum-descriptions": {"red": "is red"}},
+				"parts": {"type": "array", "items": {"type": "object", "x-go-type": "Part", "properties": {"id": {"type": "integer"}}, "required": ["id"]}}
+			},
+			"required": ["code", "colour"]}`,
+	})
+	src, err := Generate(dir, "things")
+	require.NoError(t, err)
+	out := string(src)
+
+	assert.Contains(t, out, Header)
+	assert.Contains(t, out, "package things")
+	assert.Regexp(t, `ThingEventSchema\s+= "http://example.com/thing.v2.event.json"`, out)
+	assert.Regexp(t, `CodeSchema\s+= "http://example.com/code.json"`, out)
+	assert.NotContains(t, out, "type Code ", "a schema that isn't an object is used by value")
+
+	// Responses have the fields of both objects, every one optional but ok
+	assert.Contains(t, out, "OK bool `json:\"ok\"`")
+	assert.Regexp(t, `Code\s+\*string\s+`+"`json:\"code,omitempty\"`", out)
+	assert.Regexp(t, `CreatedAt\s+\*time.Time`, out)
+	assert.Contains(t, out, "func (r *ThingGetResponse) SetErrorAttributes(err error, detail *ErrorDetail)")
+	assert.Contains(t, out, "r.CreatedAt = nil")
+	assert.Contains(t, out, "func (r *ThingGetResponse) Schema() string")
+
+	// Events carry their tenant in the subject
+	assert.Contains(t, out, `const ThingEventType = "com.example.thing.v2"`)
+	assert.Contains(t, out, "TenantID string `json:\"-\"`")
+	assert.Contains(t, out, `return "events.thing." + e.TenantID + "." + e.Code`)
+	assert.Contains(t, out, "// ColourRed is red")
+	assert.Contains(t, out, `ColourLightBlue Colour = "light_blue"`)
+	assert.Regexp(t, `Parts\s+\[\]Part\s+`+"`json:\"parts,omitempty\"`", out)
+	assert.Contains(t, out, "type Part struct")
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := map[string]string{
+		"unnamed nested object": `{"$id": "http://example.com/a.json", "type": "object",
+			"properties": {"b": {"type": "object", "properties": {"c": {"type": "string"}}}}}`,
+		"unknown ref": `{"$id": "http://example.com/a.json", "type": "object",
+			"properties": {"b": {"$ref": "http://example.com/missing.json"}}}`,
+		"subject placeholder isn't a property": `{"$id": "http://example.com/a.json", "type": "object",
+			"x-event": {"type": "a", "subject": "events.{missing}"}, "properties": {"b": {"type": "string"}}}`,
+		"response without error-detail": `{"$id": "http://example.com/a.json", "oneOf": [
+			{"type": "object", "properties": {"ok": {"const": false}, "error": {"type": "string"}}}]}`,
+		"enum declared differently": `{"$id": "http://example.com/a.json", "type": "object", "properties": {
+			"b": {"type": "string", "enum": ["x"], "x-go-type": "E"},
+			"c": {"type": "string", "enum": ["y"], "x-go-type": "E"}}}`,
+	}
+	for name, schema := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Generate(writeSchemas(t, map[string]string{"a.json": schema}), "things")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package sku puts the product SKUs that callers send into the canonical form the inventory is kept under.
+package sku
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrBadPolicy = errors.New("invalid SKU policy")
+
+// MaxLength is the longest a canonical SKU may be, the size of the product_sku column
+const MaxLength = 50
+
+// canonicalFormat matches the SKUs the inventory_product_sku_format constraint allows
+var canonicalFormat = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// The steps a Policy may take, as they are named in ParsePolicy
+const (
+	StepNormalize = "nfkc"
+	StepTrim      = "trim"
+	StepLowercase = "lowercase"
+)
+
+// Policy says how a SKU is made canonical. The steps are always taken in the same order: Unicode
+// normalisation, then trimming, then lowercasing. The zero Policy leaves SKUs as they are sent.
+type Policy struct {
+	// Normalize applies Unicode NFKC normalisation, so eg: full width letters become their ASCII equivalent
+	Normalize bool
+	// Trim removes leading and trailing white space
+	Trim bool
+	// Lowercase makes every letter lower case
+	Lowercase bool
+}
+
+// DefaultPolicy takes every step
+func DefaultPolicy() Policy {
+	return Policy{Normalize: true, Trim: true, Lowercase: true}
+}
+
+// ParsePolicy parses a comma separated list of steps, eg: "nfkc,trim,lowercase". "none" or an empty
+// string take no steps.
+func ParsePolicy(s string) (Policy, error) {
+	policy := Policy{}
+	if strings.TrimSpace(s) == "none" {
+		return policy, nil
+	}
+	for _, step := range strings.Split(s, ",") {
+		switch strings.TrimSpace(step) {
+		case "":
+		case StepNormalize:
+			policy.Normalize = true
+		case StepTrim:
+			policy.Trim = true
+		case StepLowercase:
+			policy.Lowercase = true
+		default:
+			return Policy{}, fmt.Errorf("%w: unknown step %q, must be one of %s, %s or %s", ErrBadPolicy, step, StepNormalize, StepTrim, StepLowercase)
+		}
+	}
+	return policy, nil
+}
+
+// String lists the policy's steps in the form ParsePolicy reads
+func (p Policy) String() string {
+	var steps []string
+	if p.Normalize {
+		steps = append(steps, StepNormalize)
+	}
+	if p.Trim {
+		steps = append(steps, StepTrim)
+	}
+	if p.Lowercase {
+		steps = append(steps, StepLowercase)
+	}
+	if len(steps) == 0 {
+		return "none"
+	}
+	return strings.Join(steps, ",")
+}
+
+// Canonical applies the policy's steps to the SKU. Normalising comes first, as it can turn characters
+// into white space or upper case letters that the later steps deal with.
+func (p Policy) Canonical(sku string) string {
+	if p.Normalize {
+		sku = norm.NFKC.String(sku)
+	}
+	if p.Trim {
+		sku = strings.TrimSpace(sku)
+	}
+	if p.Lowercase {
+		sku = strings.ToLower(sku)
+	}
+	return sku
+}
+
+// Valid reports whether the SKU is in the form the inventory keeps SKUs in: lower case letters, digits,
+// hyphens and underscores, and no longer than MaxLength
+func Valid(sku string) bool {
+	return len(sku) <= MaxLength && canonicalFormat.MatchString(sku)
+}
@@ -0,0 +1,49 @@
+package sku
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonical(t *testing.T) {
+	policy := DefaultPolicy()
+	assert.Equal(t, "coffee-cup", policy.Canonical("Coffee-Cup"))
+	assert.Equal(t, "coffee-cup", policy.Canonical("  coffee-cup\t"))
+	// Full width letters, and an ideographic space that normalises to white space
+	assert.Equal(t, "coffee", policy.Canonical("ＣＯＦＦＥＥ　"))
+
+	// Only the policy's steps are taken
+	assert.Equal(t, "Coffee-Cup", Policy{Trim: true}.Canonical(" Coffee-Cup "))
+	assert.Equal(t, " Coffee-Cup ", Policy{}.Canonical(" Coffee-Cup "))
+}
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("lowercase, trim")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Trim: true, Lowercase: true}, policy)
+	assert.Equal(t, "trim,lowercase", policy.String())
+
+	policy, err = ParsePolicy(DefaultPolicy().String())
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicy(), policy)
+
+	policy, err = ParsePolicy("none")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{}, policy)
+	assert.Equal(t, "none", policy.String())
+
+	_, err = ParsePolicy("trim,uppercase")
+	require.ErrorIs(t, err, ErrBadPolicy)
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid("coffee-cup_2"))
+	assert.False(t, Valid("Coffee-Cup"))
+	assert.False(t, Valid("coffee cup"))
+	assert.False(t, Valid("café"))
+	assert.False(t, Valid(""))
+	assert.False(t, Valid(strings.Repeat("a", MaxLength+1)))
+}
@@ -0,0 +1,11 @@
+package telemetry
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// GetMeter returns the meter used to record the application's metrics
+func GetMeter() metric.Meter {
+	return otel.Meter("instrumentation/beaker/main")
+}
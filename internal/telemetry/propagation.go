@@ -0,0 +1,62 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// HeaderCarrier adapts NATS message headers so the trace context can be propagated through them.
+// NATS headers are case sensitive, but trace headers can arrive in any case, eg: `Traceparent` when a
+// request is forwarded from HTTP, so Get falls back to a case insensitive match.
+type HeaderCarrier nats.Header
+
+func (c HeaderCarrier) Get(key string) string {
+	if values := c[key]; len(values) > 0 {
+		return values[0]
+	}
+	for k, values := range c {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (c HeaderCarrier) Set(key, value string) {
+	c[key] = []string{value}
+}
+
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ExtractContext returns a copy of ctx that carries the trace context and baggage held in the headers
+func ExtractContext(ctx context.Context, header nats.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier(header))
+}
+
+// InjectContext adds the trace context and baggage held in ctx to the headers, which must not be nil
+func InjectContext(ctx context.Context, header nats.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(header))
+}
+
+// MessagingAttributes returns the semantic convention attributes for an operation on a NATS message.
+// See https://opentelemetry.io/docs/specs/semconv/messaging/messaging-spans/
+func MessagingAttributes(operation attribute.KeyValue, subject string, bodySize int) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("nats"),
+		semconv.MessagingOperationName(operation.Value.AsString()),
+		operation,
+		semconv.MessagingDestinationName(subject),
+		semconv.MessagingMessageBodySize(bodySize),
+	}
+}
@@ -0,0 +1,39 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestPropagation(t *testing.T) {
+	otel.SetTextMapPropagator(newPropagator())
+
+	// Trace headers forwarded from HTTP have canonical HTTP case
+	incoming := nats.Header{"Traceparent": {testTraceParent}, "Baggage": {"tenant=acme"}}
+	ctx := ExtractContext(context.Background(), incoming)
+	spanContext := trace.SpanContextFromContext(ctx)
+	require.True(t, spanContext.IsRemote())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID().String())
+
+	outgoing := nats.Header{}
+	InjectContext(ctx, outgoing)
+	require.Equal(t, testTraceParent, outgoing.Get("traceparent"))
+	require.Equal(t, "tenant=acme", outgoing.Get("baggage"))
+}
+
+func TestHeaderCarrier(t *testing.T) {
+	carrier := HeaderCarrier{"Tracestate": {"vendor=1"}}
+	require.Equal(t, "vendor=1", carrier.Get("tracestate"))
+	require.Empty(t, carrier.Get("traceparent"))
+
+	carrier.Set("traceparent", testTraceParent)
+	require.Equal(t, testTraceParent, carrier.Get("traceparent"))
+	require.ElementsMatch(t, []string{"Tracestate", "traceparent"}, carrier.Keys())
+}
@@ -0,0 +1,76 @@
+package utility
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// BundleSchema returns the schema with the id, with every schema it references added under `$defs`,
+// so it can be used without loading anything else. Each referenced schema keeps its `$id`, so the
+// `$ref`s resolve to the copy in the bundle, see https://json-schema.org/understanding-json-schema/structuring#bundling.
+// The schemas are loaded by load, and are not changed.
+func BundleSchema(id string, load func(id string) (map[string]any, error)) (map[string]any, error) {
+	root, err := load(id)
+	if err != nil {
+		return nil, err
+	}
+	defs := map[string]any{}
+	if existing, ok := root["$defs"].(map[string]any); ok {
+		maps.Copy(defs, existing)
+	}
+	bundled := map[string]bool{id: true}
+	pending := schemaRefs(root)
+	for len(pending) > 0 {
+		ref := pending[0]
+		pending = pending[1:]
+		if bundled[ref] {
+			continue
+		}
+		bundled[ref] = true
+		doc, err := load(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bundle %s referenced by %s: %w", ref, id, err)
+		}
+		key := path.Base(ref)
+		if _, ok := defs[key]; ok {
+			key = ref
+		}
+		defs[key] = doc
+		pending = append(pending, schemaRefs(doc)...)
+	}
+
+	bundle := maps.Clone(root)
+	if len(defs) > 0 {
+		bundle["$defs"] = defs
+	}
+	return bundle, nil
+}
+
+// schemaRefs returns the documents referenced by the `$ref`s in a schema, in a stable order.
+// References within the same document, eg: `#/$defs/sku`, are left out.
+func schemaRefs(schema any) []string {
+	var refs []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				if doc, _, _ := strings.Cut(ref, "#"); doc != "" {
+					refs = append(refs, doc)
+				}
+			}
+			for _, key := range slices.Sorted(maps.Keys(v)) {
+				walk(v[key])
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+	walk(schema)
+	return refs
+}
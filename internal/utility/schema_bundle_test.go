@@ -0,0 +1,69 @@
+package utility
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleSchema(t *testing.T) {
+	documents := map[string]map[string]any{
+		"http://example.com/order.json": {
+			"$id":  "http://example.com/order.json",
+			"type": "object",
+			"properties": map[string]any{
+				"sku":   map[string]any{"$ref": "http://example.com/sku.json"},
+				"skus":  map[string]any{"type": "array", "items": map[string]any{"$ref": "http://example.com/sku.json"}},
+				"note":  map[string]any{"$ref": "#/$defs/note"},
+				"price": map[string]any{"$ref": "http://example.com/price.json#/$defs/amount"},
+			},
+			"$defs": map[string]any{"note": map[string]any{"type": "string"}},
+		},
+		"http://example.com/sku.json": {
+			"$id":     "http://example.com/sku.json",
+			"type":    "string",
+			"pattern": "^[a-z]+$",
+		},
+		"http://example.com/price.json": {
+			"$id":   "http://example.com/price.json",
+			"$defs": map[string]any{"amount": map[string]any{"$ref": "http://example.com/sku.json", "type": "string"}},
+		},
+	}
+	load := func(id string) (map[string]any, error) {
+		if doc, ok := documents[id]; ok {
+			return doc, nil
+		}
+		return nil, fmt.Errorf("not found: %s", id)
+	}
+
+	bundle, err := BundleSchema("http://example.com/order.json", load)
+	require.NoError(t, err)
+	defs := bundle["$defs"].(map[string]any)
+	assert.ElementsMatch(t, []string{"note", "sku.json", "price.json"}, slices.Collect(maps.Keys(defs)))
+	assert.NotContains(t, documents["http://example.com/order.json"]["$defs"], "sku.json", "the loaded document is not changed")
+
+	// The bundle validates without loading anything else
+	compiler := jsonschema.NewCompiler()
+	require.NoError(t, compiler.AddResource("http://example.com/bundle.json", bundle))
+	schema, err := compiler.Compile("http://example.com/bundle.json")
+	require.NoError(t, err)
+	valid, err := jsonschema.UnmarshalJSON(bytes.NewReader([]byte(`{"sku": "abc", "skus": ["def"]}`)))
+	require.NoError(t, err)
+	require.NoError(t, schema.Validate(valid))
+	invalid, err := jsonschema.UnmarshalJSON(bytes.NewReader([]byte(`{"sku": "ABC"}`)))
+	require.NoError(t, err)
+	require.Error(t, schema.Validate(invalid))
+
+	_, err = BundleSchema("http://example.com/missing.json", load)
+	require.Error(t, err)
+
+	documents["http://example.com/order.json"]["properties"].(map[string]any)["missing"] = map[string]any{"$ref": "http://example.com/missing.json"}
+	_, err = BundleSchema("http://example.com/order.json", load)
+	require.ErrorContains(t, err, "missing.json")
+}
@@ -0,0 +1,59 @@
+package utility
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+var ErrUnknownSchema = errors.New("schema is not registered")
+
+// SchemaRegistry holds compiled JSON schemas, so they are compiled once at startup rather than on
+// every request. A registry is read only once it is created, so it is safe for concurrent use.
+type SchemaRegistry struct {
+	schemas map[string]*jsonschema.Schema
+}
+
+// NewSchemaRegistry compiles every one of the schemas. It returns an error listing every schema
+// that fails to compile, so all the mistakes can be fixed at once.
+func NewSchemaRegistry(compiler *jsonschema.Compiler, ids ...string) (*SchemaRegistry, error) {
+	if compiler == nil {
+		return nil, errors.New("JSON schema compiler is not initialized")
+	}
+	registry := &SchemaRegistry{schemas: map[string]*jsonschema.Schema{}}
+	var errs []error
+	for _, id := range ids {
+		if _, ok := registry.schemas[id]; ok {
+			continue
+		}
+		schema, err := compiler.Compile(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to compile schema %s: %w", id, err))
+			continue
+		}
+		registry.schemas[id] = schema
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Get returns the compiled schema with the id
+func (r *SchemaRegistry) Get(id string) (*jsonschema.Schema, error) {
+	schema, ok := r.schemas[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchema, id)
+	}
+	return schema, nil
+}
+
+// IDs returns the id of every registered schema
+func (r *SchemaRegistry) IDs() []string {
+	ids := make([]string, 0, len(r.schemas))
+	for id := range r.schemas {
+		ids = append(ids, id)
+	}
+	return ids
+}
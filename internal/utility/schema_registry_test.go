@@ -0,0 +1,41 @@
+package utility
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSchemaRegistry(t *testing.T) {
+	compiler, err := NewJSONSchemaCompiler(t.Context(), filepath.Join(".", "..", "..", "schemas"))
+	require.NoError(t, err)
+
+	addRequest := "http://github.com/davidoram/beaker/schemas/stock-add.request.json"
+	getRequest := "http://github.com/davidoram/beaker/schemas/stock-get.request.json"
+	registry, err := NewSchemaRegistry(compiler, addRequest, getRequest, addRequest)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{addRequest, getRequest}, registry.IDs())
+
+	schema, err := registry.Get(addRequest)
+	require.NoError(t, err)
+	data, err := jsonschema.UnmarshalJSON(bytes.NewReader([]byte(`{"product-sku": "abc-123", "quantity": 5}`)))
+	require.NoError(t, err)
+	require.NoError(t, schema.Validate(data))
+
+	_, err = registry.Get("http://github.com/davidoram/beaker/schemas/stock-remove.request.json")
+	require.ErrorIs(t, err, ErrUnknownSchema)
+}
+
+func TestNewSchemaRegistry_CompileError(t *testing.T) {
+	compiler, err := NewJSONSchemaCompiler(t.Context(), filepath.Join(".", "..", "..", "schemas"))
+	require.NoError(t, err)
+
+	_, err = NewSchemaRegistry(compiler,
+		"http://github.com/davidoram/beaker/schemas/stock-add.request.json",
+		"http://github.com/davidoram/beaker/schemas/does-not-exist.json",
+	)
+	require.ErrorContains(t, err, "does-not-exist.json")
+}
@@ -1,17 +1,47 @@
+-- name: SetTenant :exec
+-- Scopes the row level security policies to a tenant for the rest of the transaction
+SELECT set_config('beaker.tenant_id', @tenant_id::text, true);
+
 -- name: AddInventory :one
-INSERT INTO inventory (product_sku, stock_level)
-VALUES ($1, $2)
-ON CONFLICT (product_sku)
+INSERT INTO inventory (tenant_id, product_sku, stock_level)
+VALUES ($1, $2, $3)
+ON CONFLICT (tenant_id, product_sku)
 DO UPDATE SET stock_level = inventory.stock_level + EXCLUDED.stock_level
-RETURNING product_sku, stock_level;
+RETURNING product_sku, stock_level, tenant_id;
 
 -- name: RemoveInventory :one
 UPDATE inventory
-SET stock_level = stock_level - $2
-WHERE product_sku = $1
-RETURNING product_sku, stock_level;
+SET stock_level = stock_level - $3
+WHERE tenant_id = $1 AND product_sku = $2
+RETURNING product_sku, stock_level, tenant_id;
 
 -- name: GetInventory :one
-SELECT product_sku, stock_level
+SELECT product_sku, stock_level, tenant_id
+FROM inventory
+WHERE tenant_id = $1 AND product_sku = $2;
+
+-- name: ListInventoryPage :many
+SELECT product_sku, stock_level, tenant_id
 FROM inventory
-WHERE product_sku = $1;
+WHERE tenant_id = @tenant_id AND product_sku > @after_sku::varchar
+ORDER BY product_sku
+LIMIT @page_size::int;
+
+-- name: GetInventories :many
+SELECT product_sku, stock_level, tenant_id
+FROM inventory
+WHERE tenant_id = @tenant_id AND product_sku = ANY(@product_skus::varchar[])
+ORDER BY product_sku;
+
+-- name: ResolveSkuAliases :many
+-- Returns the product SKU each alias stands for, aliases that aren't known are left out
+SELECT alias, product_sku
+FROM sku_aliases
+WHERE tenant_id = @tenant_id AND alias = ANY(@aliases::varchar[]);
+
+-- name: AddSkuAlias :exec
+-- The alias is stored as given, use api.AddSkuAlias to put it into canonical form first
+INSERT INTO sku_aliases (tenant_id, alias, product_sku, alias_type)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (tenant_id, alias)
+DO UPDATE SET product_sku = EXCLUDED.product_sku, alias_type = EXCLUDED.alias_type;
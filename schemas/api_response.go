@@ -1,6 +1,10 @@
 package schemas
 
 type APIResponse interface {
-	// Given a response, set all the error attributes, and clear the success attributes
-	SetErrorAttributes(err error)
+	// Given a response, set all the error attributes, and clear the success attributes.
+	// The detail may be nil, when only the legacy error message is available.
+	SetErrorAttributes(err error, detail *ErrorDetail)
+
+	// Schema returns the ID of the JSON schema that the response conforms to
+	Schema() string
 }
@@ -0,0 +1,38 @@
+package schemas
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SchemaBaseURL prefixes the ID of every schema in this directory
+const SchemaBaseURL = "http://github.com/davidoram/beaker/schemas/"
+
+var ErrUnknownSchema = errors.New("unknown schema")
+
+//go:embed *.json
+var documents embed.FS
+
+// Document returns the JSON document of the schema with the id, as built into the binary. Numbers
+// are decoded as json.Number, so the document is unchanged when it is encoded again.
+func Document(id string) (map[string]any, error) {
+	name, ok := strings.CutPrefix(id, SchemaBaseURL)
+	if !ok || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchema, id)
+	}
+	data, err := documents.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchema, id)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode schema %s: %w", id, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,19 @@
+package schemas
+
+import "github.com/davidoram/beaker/internal/utility"
+
+// ErrorResponse is a failed response. Every response schema accepts it, so it is used to reply
+// when a request is rejected before it reaches the endpoint's handler.
+type ErrorResponse struct {
+	// OK is always false
+	OK bool `json:"ok"`
+
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+func (r *ErrorResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+}
@@ -0,0 +1,39 @@
+package schemas
+
+import "strings"
+
+// Event is implemented by every event the service publishes
+type Event interface {
+	// Subject returns the NATS subject that the event will be published to
+	Subject() string
+
+	// Type returns the CloudEvents type of the event. The type ends with the schema version, so a
+	// breaking change to an event is published as a new type.
+	Type() string
+
+	// DataSchema returns the ID of the versioned JSON schema that the event conforms to
+	DataSchema() string
+}
+
+const (
+	// Deprecated: use LowStockEventSchema
+	LockStockEventSchema = LowStockEventSchema
+
+	// StockChangedSubjectPrefix is the start of the subject each StockChangedEvent is published to.
+	// Subscribe to StockChangedSubjectPrefix + ">" to receive changes to every product of every tenant,
+	// or StockChangedSubjectPrefix + "<tenant>.>" for a single tenant.
+	StockChangedSubjectPrefix = "events.stock.changed."
+)
+
+// ParseStockChangedSubject splits a StockChangedEvent subject into its tenant and product SKU
+func ParseStockChangedSubject(subject string) (tenant string, sku string, ok bool) {
+	rest, ok := strings.CutPrefix(subject, StockChangedSubjectPrefix)
+	if !ok {
+		return "", "", false
+	}
+	tenant, sku, ok = strings.Cut(rest, ".")
+	if !ok || tenant == "" || sku == "" {
+		return "", "", false
+	}
+	return tenant, sku, true
+}
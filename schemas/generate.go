@@ -0,0 +1,5 @@
+package schemas
+
+// The request, response and event types are generated from the JSON schemas in this directory. After
+// changing a schema, run `go generate ./schemas` and commit the schemas_gen.go it writes.
+//go:generate go run ../cmd/schemagen -dir . -out schemas_gen.go
@@ -0,0 +1,619 @@
+// Code generated by schemagen from the JSON schemas in this directory. DO NOT EDIT.
+
+package schemas
+
+import (
+	"time"
+
+	"github.com/davidoram/beaker/internal/utility"
+)
+
+// IDs of the JSON schemas
+const (
+	ErrorDetailSchema              = "http://github.com/davidoram/beaker/schemas/error-detail.json"
+	HealthResponseSchema           = "http://github.com/davidoram/beaker/schemas/health.response.json"
+	InventoryRecordSchema          = "http://github.com/davidoram/beaker/schemas/inventory-record.json"
+	LowStockEventSchema            = "http://github.com/davidoram/beaker/schemas/low-stock.v1.event.json"
+	ProductSKUReferenceSchema      = "http://github.com/davidoram/beaker/schemas/product-sku-reference.json"
+	ProductSKUSchema               = "http://github.com/davidoram/beaker/schemas/product-sku.json"
+	StockAddRequestSchema          = "http://github.com/davidoram/beaker/schemas/stock-add.request.json"
+	StockAddResponseSchema         = "http://github.com/davidoram/beaker/schemas/stock-add.response.json"
+	StockChangedEventSchema        = "http://github.com/davidoram/beaker/schemas/stock-changed.v1.event.json"
+	StockGetRequestSchema          = "http://github.com/davidoram/beaker/schemas/stock-get.request.json"
+	StockGetResponseSchema         = "http://github.com/davidoram/beaker/schemas/stock-get.response.json"
+	StockListRequestSchema         = "http://github.com/davidoram/beaker/schemas/stock-list.request.json"
+	StockListResponseSchema        = "http://github.com/davidoram/beaker/schemas/stock-list.response.json"
+	StockRemoveRequestSchema       = "http://github.com/davidoram/beaker/schemas/stock-remove.request.json"
+	StockRemoveResponseSchema      = "http://github.com/davidoram/beaker/schemas/stock-remove.response.json"
+	StockSchemaRequestSchema       = "http://github.com/davidoram/beaker/schemas/stock-schema.request.json"
+	StockSchemaResponseSchema      = "http://github.com/davidoram/beaker/schemas/stock-schema.response.json"
+	StockWatchCancelRequestSchema  = "http://github.com/davidoram/beaker/schemas/stock-watch-cancel.request.json"
+	StockWatchCancelResponseSchema = "http://github.com/davidoram/beaker/schemas/stock-watch-cancel.response.json"
+	StockWatchRenewRequestSchema   = "http://github.com/davidoram/beaker/schemas/stock-watch-renew.request.json"
+	StockWatchRenewResponseSchema  = "http://github.com/davidoram/beaker/schemas/stock-watch-renew.response.json"
+	StockWatchRequestSchema        = "http://github.com/davidoram/beaker/schemas/stock-watch.request.json"
+	StockWatchResponseSchema       = "http://github.com/davidoram/beaker/schemas/stock-watch.response.json"
+	StockWatchUpdateSchema         = "http://github.com/davidoram/beaker/schemas/stock-watch.update.json"
+)
+
+// ErrorDetail corresponds to the error-detail.json schema.
+// Describes why a request failed, in a form that callers can act on without parsing the error message.
+type ErrorDetail struct {
+	// Identifies the kind of error. Codes are stable, unlike messages, so callers should branch on the code.
+	Code ErrorCode `json:"code"`
+	// Describes the error for a human reader.
+	Message string `json:"message"`
+	// Whether the error was caused by the caller, or occurred inside our system.
+	Type ErrorType `json:"type"`
+	// The locations in the request, as JSON pointers, that did not conform to the request schema.
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Schema returns the ID of the error-detail.json schema
+func (r *ErrorDetail) Schema() string {
+	return ErrorDetailSchema
+}
+
+// ErrorCode holds the values of the code property of the error-detail.json schema.
+// Identifies the kind of error. Codes are stable, unlike messages, so callers should branch on the code.
+type ErrorCode string
+
+const (
+	// ErrorCodeValidationFailed means the request didn't conform to its schema
+	ErrorCodeValidationFailed ErrorCode = "validation_failed"
+	// ErrorCodeInsufficientStock means there isn't enough stock to remove the requested quantity
+	ErrorCodeInsufficientStock ErrorCode = "insufficient_stock"
+	// ErrorCodeNotFound means the thing the request names doesn't exist
+	ErrorCodeNotFound ErrorCode = "not_found"
+	// ErrorCodeConflict means the request breaks a business rule given the current state of the inventory
+	ErrorCodeConflict ErrorCode = "conflict"
+	// ErrorCodeUnauthorized means the caller didn't say who they are
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrorCodeForbidden means the caller isn't allowed to make the request
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// ErrorCodeRateLimited means the caller has made too many requests, and should retry later
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+	// ErrorCodeTimeout means the request ran past its deadline, and its changes were rolled back
+	ErrorCodeTimeout ErrorCode = "timeout"
+	// ErrorCodeOverloaded means the service is handling as many requests as it can, the caller should retry later
+	ErrorCodeOverloaded ErrorCode = "overloaded"
+	// ErrorCodeUnavailable means the service is shutting down, the caller should retry with another instance
+	ErrorCodeUnavailable ErrorCode = "unavailable"
+	// ErrorCodeInvalidRequest is used for any other caller error
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrorCodeInternal means the request failed because of a problem inside our system
+	ErrorCodeInternal ErrorCode = "internal"
+)
+
+// ErrorType holds the values of the type property of the error-detail.json schema.
+// Whether the error was caused by the caller, or occurred inside our system.
+type ErrorType string
+
+const (
+	ErrorTypeCaller ErrorType = "caller"
+	ErrorTypeSystem ErrorType = "system"
+)
+
+// HealthResponse corresponds to the health.response.json schema.
+// Whether the service is ready to handle requests, and the outcome of each check that decided it.
+type HealthResponse struct {
+	// pass when every check passed, and the service is ready to handle requests.
+	Status HealthStatus  `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// Schema returns the ID of the health.response.json schema
+func (r *HealthResponse) Schema() string {
+	return HealthResponseSchema
+}
+
+// HealthStatus holds the values of the status property of the health.response.json schema.
+// pass when every check passed, and the service is ready to handle requests.
+type HealthStatus string
+
+const (
+	HealthStatusPass HealthStatus = "pass"
+	HealthStatusFail HealthStatus = "fail"
+)
+
+// HealthCheck is the type of the items of the checks property of the health.response.json schema.
+// The outcome of one check.
+type HealthCheck struct {
+	// What was checked, eg: postgres.
+	Name   string       `json:"name"`
+	Status HealthStatus `json:"status"`
+	// How long the check took, in milliseconds.
+	LatencyMs float64 `json:"latency-ms"`
+	// Why the check failed.
+	Error *string `json:"error,omitempty"`
+}
+
+// InventoryRecord corresponds to the inventory-record.json schema.
+// A single row of an inventory export. Only the columns selected for the export are present.
+type InventoryRecord struct {
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU *string `json:"product-sku,omitempty"`
+	// The stock level of the product at the time of the export.
+	StockLevel *int `json:"stock-level,omitempty"`
+}
+
+// Schema returns the ID of the inventory-record.json schema
+func (r *InventoryRecord) Schema() string {
+	return InventoryRecordSchema
+}
+
+// LowStockEventType is the CloudEvents type of LowStockEvent
+const LowStockEventType = "com.github.davidoram.beaker.low-stock.v1"
+
+// LowStockEvent corresponds to the low-stock.v1.event.json schema.
+// Published when removing stock leaves the stock level of a product below the low stock threshold.
+type LowStockEvent struct {
+	// TenantID is carried in the subject rather than the payload
+	TenantID string `json:"-"`
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU string `json:"product-sku"`
+	// The current stock level of the product.
+	StockLevel int `json:"stock-level"`
+}
+
+// Subject returns the NATS subject that LowStockEvent is published to, `events.low_stock.{tenant}`
+func (e LowStockEvent) Subject() string {
+	return "events.low_stock." + e.TenantID
+}
+
+// Type returns the CloudEvents type of LowStockEvent
+func (e LowStockEvent) Type() string {
+	return LowStockEventType
+}
+
+// DataSchema returns the ID of the schema that LowStockEvent conforms to
+func (e LowStockEvent) DataSchema() string {
+	return LowStockEventSchema
+}
+
+// StockAddRequest corresponds to the stock-add.request.json schema.
+type StockAddRequest struct {
+	// The SKU (Stock Keeping Unit) identifier for the product, or an alias of it such as a barcode, GTIN or legacy code. The service puts it into canonical form, and resolves an alias to the SKU it stands for.
+	ProductSKU string `json:"product-sku"`
+	// The number of units to add, must be at least 1.
+	Quantity int `json:"quantity"`
+}
+
+// Schema returns the ID of the stock-add.request.json schema
+func (r *StockAddRequest) Schema() string {
+	return StockAddRequestSchema
+}
+
+// StockAddResponse corresponds to the stock-add.response.json schema.
+type StockAddResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU *string `json:"product-sku,omitempty"`
+	Quantity   *int    `json:"quantity,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockAddResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.ProductSKU = nil
+	r.Quantity = nil
+}
+
+// Schema returns the ID of the stock-add.response.json schema
+func (r *StockAddResponse) Schema() string {
+	return StockAddResponseSchema
+}
+
+// StockChangedEventType is the CloudEvents type of StockChangedEvent
+const StockChangedEventType = "com.github.davidoram.beaker.stock-changed.v1"
+
+// StockChangedEvent corresponds to the stock-changed.v1.event.json schema.
+// Published each time the stock level of a product changes.
+type StockChangedEvent struct {
+	// TenantID is carried in the subject rather than the payload
+	TenantID string `json:"-"`
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU string `json:"product-sku"`
+	// The operation that changed the stock level.
+	Operation StockOperation `json:"operation"`
+	// The change in stock level, negative when stock is removed.
+	Delta int `json:"delta"`
+	// The stock level before the change.
+	OldLevel int `json:"old-level"`
+	// The stock level after the change.
+	NewLevel int `json:"new-level"`
+	// Identifies the API request that made the change.
+	RequestID string `json:"request-id"`
+}
+
+// Subject returns the NATS subject that StockChangedEvent is published to, `events.stock.changed.{tenant}.{product-sku}`
+func (e StockChangedEvent) Subject() string {
+	return "events.stock.changed." + e.TenantID + "." + e.ProductSKU
+}
+
+// Type returns the CloudEvents type of StockChangedEvent
+func (e StockChangedEvent) Type() string {
+	return StockChangedEventType
+}
+
+// DataSchema returns the ID of the schema that StockChangedEvent conforms to
+func (e StockChangedEvent) DataSchema() string {
+	return StockChangedEventSchema
+}
+
+// StockOperation holds the values of the operation property of the stock-changed.v1.event.json schema.
+// The operation that changed the stock level.
+type StockOperation string
+
+const (
+	StockOperationAdd    StockOperation = "add"
+	StockOperationRemove StockOperation = "remove"
+)
+
+// StockGetRequest corresponds to the stock-get.request.json schema.
+type StockGetRequest struct {
+	// The SKU (Stock Keeping Unit) identifier for the product, or an alias of it such as a barcode, GTIN or legacy code. The service puts it into canonical form, and resolves an alias to the SKU it stands for.
+	ProductSKU string `json:"product-sku"`
+}
+
+// Schema returns the ID of the stock-get.request.json schema
+func (r *StockGetRequest) Schema() string {
+	return StockGetRequestSchema
+}
+
+// StockGetResponse corresponds to the stock-get.response.json schema.
+type StockGetResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU *string `json:"product-sku,omitempty"`
+	Quantity   *int    `json:"quantity,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockGetResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.ProductSKU = nil
+	r.Quantity = nil
+}
+
+// Schema returns the ID of the stock-get.response.json schema
+func (r *StockGetResponse) Schema() string {
+	return StockGetResponseSchema
+}
+
+// StockListRequest corresponds to the stock-list.request.json schema.
+type StockListRequest struct {
+	// List the products that sort after this SKU, the next-after of the previous page. When missing the list starts at the first product.
+	After *string `json:"after,omitempty"`
+	// The most products to list. Defaults to 100.
+	Limit *int `json:"limit,omitempty"`
+}
+
+// Schema returns the ID of the stock-list.request.json schema
+func (r *StockListRequest) Schema() string {
+	return StockListRequestSchema
+}
+
+// StockListResponse corresponds to the stock-list.response.json schema.
+type StockListResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	// The stock level of each product, in order of their SKU.
+	StockLevels []StockLevel `json:"stock-levels,omitempty"`
+	// Pass as after to list the next page. Missing on the last page.
+	NextAfter *string `json:"next-after,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockListResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.StockLevels = nil
+	r.NextAfter = nil
+}
+
+// Schema returns the ID of the stock-list.response.json schema
+func (r *StockListResponse) Schema() string {
+	return StockListResponseSchema
+}
+
+// StockLevel is the type of the items of the stock-levels property of the stock-list.response.json schema.
+// The stock level of a single product.
+type StockLevel struct {
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU string `json:"product-sku"`
+	Quantity   int    `json:"quantity"`
+}
+
+// StockRemoveRequest corresponds to the stock-remove.request.json schema.
+type StockRemoveRequest struct {
+	// The SKU (Stock Keeping Unit) identifier for the product, or an alias of it such as a barcode, GTIN or legacy code. The service puts it into canonical form, and resolves an alias to the SKU it stands for.
+	ProductSKU string `json:"product-sku"`
+	// The number of units to remove, must be at least 1.
+	Quantity int `json:"quantity"`
+}
+
+// Schema returns the ID of the stock-remove.request.json schema
+func (r *StockRemoveRequest) Schema() string {
+	return StockRemoveRequestSchema
+}
+
+// StockRemoveResponse corresponds to the stock-remove.response.json schema.
+type StockRemoveResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU *string `json:"product-sku,omitempty"`
+	Quantity   *int    `json:"quantity,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockRemoveResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.ProductSKU = nil
+	r.Quantity = nil
+}
+
+// Schema returns the ID of the stock-remove.response.json schema
+func (r *StockRemoveResponse) Schema() string {
+	return StockRemoveResponseSchema
+}
+
+// StockSchemaRequest corresponds to the stock-schema.request.json schema.
+type StockSchemaRequest struct {
+	// Subject of the endpoint to describe, eg: stock.add. When missing every endpoint is described.
+	Endpoint *string `json:"endpoint,omitempty"`
+}
+
+// Schema returns the ID of the stock-schema.request.json schema
+func (r *StockSchemaRequest) Schema() string {
+	return StockSchemaRequestSchema
+}
+
+// StockSchemaResponse corresponds to the stock-schema.response.json schema.
+type StockSchemaResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	Endpoints []EndpointSchemas `json:"endpoints,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockSchemaResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.Endpoints = nil
+}
+
+// Schema returns the ID of the stock-schema.response.json schema
+func (r *StockSchemaResponse) Schema() string {
+	return StockSchemaResponseSchema
+}
+
+// EndpointSchemas is the type of the items of the endpoints property of the stock-schema.response.json schema.
+// Describes the requests and responses of an endpoint.
+type EndpointSchemas struct {
+	// Name of the endpoint in the service info.
+	Name string `json:"name"`
+	// Subject the endpoint is called on, eg: stock.v1.add.
+	Subject string `json:"subject"`
+	// API version the endpoint belongs to, eg: v1.
+	Version string `json:"version"`
+	// The schema that requests conform to, with the schemas it references bundled under $defs.
+	RequestSchema map[string]any `json:"request-schema"`
+	// The schema that responses conform to, with the schemas it references bundled under $defs.
+	ResponseSchema map[string]any `json:"response-schema"`
+}
+
+// StockWatchCancelRequest corresponds to the stock-watch-cancel.request.json schema.
+type StockWatchCancelRequest struct {
+	// The watch to cancel.
+	WatchID string `json:"watch-id"`
+}
+
+// Schema returns the ID of the stock-watch-cancel.request.json schema
+func (r *StockWatchCancelRequest) Schema() string {
+	return StockWatchCancelRequestSchema
+}
+
+// StockWatchCancelResponse corresponds to the stock-watch-cancel.response.json schema.
+type StockWatchCancelResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	WatchID *string `json:"watch-id,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockWatchCancelResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.WatchID = nil
+}
+
+// Schema returns the ID of the stock-watch-cancel.response.json schema
+func (r *StockWatchCancelResponse) Schema() string {
+	return StockWatchCancelResponseSchema
+}
+
+// StockWatchRenewRequest corresponds to the stock-watch-renew.request.json schema.
+type StockWatchRenewRequest struct {
+	// The watch to renew.
+	WatchID string `json:"watch-id"`
+	// How long the watch lasts from now unless it is renewed again. Defaults to 60 seconds.
+	LeaseSeconds *int `json:"lease-seconds,omitempty"`
+}
+
+// Schema returns the ID of the stock-watch-renew.request.json schema
+func (r *StockWatchRenewRequest) Schema() string {
+	return StockWatchRenewRequestSchema
+}
+
+// StockWatchRenewResponse corresponds to the stock-watch-renew.response.json schema.
+type StockWatchRenewResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	WatchID        *string    `json:"watch-id,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease-expires-at,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockWatchRenewResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.WatchID = nil
+	r.LeaseExpiresAt = nil
+}
+
+// Schema returns the ID of the stock-watch-renew.response.json schema
+func (r *StockWatchRenewResponse) Schema() string {
+	return StockWatchRenewResponseSchema
+}
+
+// StockWatchRequest corresponds to the stock-watch.request.json schema.
+type StockWatchRequest struct {
+	// The products to watch.
+	ProductSKUs []string `json:"product-skus"`
+	// The NATS subject that stock level updates are published to. Subscribe to it before sending the request. It must be under the service's inbox prefix, by default a subject created with NewInbox(), eg: _INBOX.abc123.
+	Inbox string `json:"inbox"`
+	// How long the watch lasts unless it is renewed. Defaults to 60 seconds.
+	LeaseSeconds *int `json:"lease-seconds,omitempty"`
+}
+
+// Schema returns the ID of the stock-watch.request.json schema
+func (r *StockWatchRequest) Schema() string {
+	return StockWatchRequestSchema
+}
+
+// StockWatchResponse corresponds to the stock-watch.response.json schema.
+type StockWatchResponse struct {
+	// Indicates if the request was successful.
+	OK bool `json:"ok"`
+
+	// Success response fields
+
+	// Identifies the watch when renewing or cancelling it.
+	WatchID *string `json:"watch-id,omitempty"`
+	// When the watch ends unless it is renewed.
+	LeaseExpiresAt *time.Time `json:"lease-expires-at,omitempty"`
+	// The stock level of each watched product when the watch started.
+	StockLevels []StockLevel `json:"stock-levels,omitempty"`
+
+	// Error response fields
+
+	// Error message if the request failed. Deprecated, use error-detail instead.
+	Error       *string      `json:"error,omitempty"`
+	ErrorDetail *ErrorDetail `json:"error-detail,omitempty"`
+}
+
+// SetErrorAttributes sets the error response fields, and clears the success response fields
+func (r *StockWatchResponse) SetErrorAttributes(err error, detail *ErrorDetail) {
+	r.Error = utility.Ptr(err.Error())
+	r.ErrorDetail = detail
+	r.OK = false
+
+	r.WatchID = nil
+	r.LeaseExpiresAt = nil
+	r.StockLevels = nil
+}
+
+// Schema returns the ID of the stock-watch.response.json schema
+func (r *StockWatchResponse) Schema() string {
+	return StockWatchResponseSchema
+}
+
+// StockWatchUpdate corresponds to the stock-watch.update.json schema.
+// Published to a watch inbox each time the stock level of a watched product changes.
+type StockWatchUpdate struct {
+	// The watch that the update belongs to.
+	WatchID string `json:"watch-id"`
+	// The SKU (Stock Keeping Unit) identifier for the product.
+	ProductSKU string `json:"product-sku"`
+	// The new stock level of the product.
+	Quantity int `json:"quantity"`
+}
+
+// Schema returns the ID of the stock-watch.update.json schema
+func (r *StockWatchUpdate) Schema() string {
+	return StockWatchUpdateSchema
+}